@@ -1,26 +1,41 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
 	"regexp"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/ghodss/yaml"
 	"github.com/openshift/origin/pkg/cmd/server/bootstrappolicy"
 	"github.com/openshift/origin/pkg/security/apis/security"
-	"gopkg.in/yaml.v2"
+	securityinternalclientset "github.com/openshift/origin/pkg/security/generated/internalclientset"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	kjson "k8s.io/apimachinery/pkg/runtime/serializer/json"
+	"k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/kubernetes/pkg/api/legacyscheme"
 
 	//"k8s.io/kubernetes/pkg/apis/apps"
 	//"k8s.io/kubernetes/pkg/apis/batch"
-	//"k8s.io/kubernetes/pkg/apis/core"
 
+	"k8s.io/kubernetes/pkg/apis/core"
 	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset"
 )
 
@@ -88,59 +103,820 @@ var (
 	codec      = legacyscheme.Codecs.CodecForVersions(nil, serializer, nil, runtime.InternalGroupVersioner)
 )
 
+const (
+	defaultConfigPath    = "/etc/aro-admission-controller/aro-admission-controller.yaml"
+	defaultTLSCertFile   = "/etc/aro-admission-controller/aro-admission-controller.crt"
+	defaultTLSKeyFile    = "/etc/aro-admission-controller/aro-admission-controller.key"
+	defaultListenAddress = ":8443"
+)
+
+// registerFlags registers the command line flags on the given FlagSet
+func registerFlags(fs *flag.FlagSet) {
+	fs.String("config", defaultConfigPath, "path to the configuration file")
+	fs.String("tls-cert", defaultTLSCertFile, "path to the TLS certificate, overrides the config file")
+	fs.String("tls-key", defaultTLSKeyFile, "path to the TLS key, overrides the config file")
+	fs.String("listen", defaultListenAddress, "address to listen on, overrides the config file")
+	fs.Bool("validate-config", false, "validate the configuration file and exit without starting the server")
+	fs.Bool("gen-webhook-config", false, "print the ValidatingWebhookConfiguration and ClusterRoleBinding manifests and exit")
+}
+
+// whitelistProfile constrains what one whitelist pattern grants the
+// containers it matches.  A pattern without a profile keeps the blanket
+// allow whitelisting has always granted.
+type whitelistProfile struct {
+	//AllowPrivileged permits privileged containers
+	AllowPrivileged bool `json:"allowPrivileged"`
+	//AllowHostNetwork permits running in the host network namespace
+	AllowHostNetwork bool `json:"allowHostNetwork"`
+	//AllowedCapabilities lists the capabilities containers may add; empty
+	//means none may be added
+	AllowedCapabilities []string `json:"allowedCapabilities"`
+	//AllowedPullPolicies restricts the imagePullPolicy of matched
+	//containers, e.g. requiring Always so a mutable tag is re-verified on
+	//every start, or forbidding it for digest-pinned images; empty leaves
+	//the pull policy unconstrained
+	AllowedPullPolicies []string `json:"allowedPullPolicies"`
+}
+
 type config struct {
 	Whitelist []string `json:"whitelist"`
+	Denylist  []string `json:"denylist"`
+	//WhitelistProfiles maps a whitelist pattern to the privileges pods
+	//matched by it may actually use, instead of the blanket allow
+	WhitelistProfiles map[string]whitelistProfile `json:"whitelistProfiles"`
+	//RejectHostAliases denies non-whitelisted pods which set spec.hostAliases
+	RejectHostAliases bool `json:"rejectHostAliases"`
+	//RequireTokenAutomountDisabled denies non-whitelisted pods unless they
+	//set automountServiceAccountToken to false
+	RequireTokenAutomountDisabled bool `json:"requireTokenAutomountDisabled"`
+	//ProtectedSCCFiles are additional protected SCC templates merged with the
+	//built-in set
+	ProtectedSCCFiles []string `json:"protectedSCCFiles"`
+	//MaxRequestBytes limits the size of accepted request bodies, 0 means the
+	//default of 4MB
+	MaxRequestBytes int64 `json:"maxRequestBytes"`
+	//DenialMessagePrefix and DenialMessageSuffix are added around denial
+	//messages, e.g. to point customers at the image whitelisting process
+	DenialMessagePrefix string `json:"denialMessagePrefix"`
+	DenialMessageSuffix string `json:"denialMessageSuffix"`
+	//RequireFullyQualifiedImages denies whitelisted pods whose images are not
+	//pinned to a registry and tag or digest
+	RequireFullyQualifiedImages bool `json:"requireFullyQualifiedImages"`
+	//RejectPodOverhead denies non-whitelisted pods setting spec.overhead,
+	//which can shift node capacity accounting
+	RejectPodOverhead bool `json:"rejectPodOverhead"`
+	//ReservedResources lists extended resource names (e.g. example.com/gpu)
+	//non-whitelisted pods may not request; they are reserved for platform
+	//workloads
+	ReservedResources []string `json:"reservedResources"`
+	//ValidateNodeSelectorInitContainers validates the init containers of
+	//pods admitted by the master/infra node-selector bypass instead of
+	//skipping them with the rest of the pod: privileged init containers
+	//whose images are not whitelisted are denied
+	ValidateNodeSelectorInitContainers bool `json:"validateNodeSelectorInitContainers"`
+	//VerboseSCCLogging dumps the raw SCC object of each review request to
+	//the log for debugging; it is off by default because the raw object
+	//includes the SCC's user and group lists
+	VerboseSCCLogging bool `json:"verboseSCCLogging"`
+	//HonorPrivilegedSCCBindings allows pods whose service account is itself
+	//granted use of the privileged SCC (checked via SubjectAccessReview),
+	//without image whitelisting, bringing behavior closer to vanilla
+	//OpenShift for customers managing access through SCC bindings
+	HonorPrivilegedSCCBindings bool `json:"honorPrivilegedSCCBindings"`
+	//TrustedOwners lists ownerReference kinds whose controller-owned pods
+	//are allowed without image whitelisting, either as a bare kind trusted
+	//in every namespace or as namespace/Kind limiting the trust to one
+	//namespace; this avoids whitelisting every image a platform controller
+	//stamps out.  ownerReferences are client-settable and unverified by the
+	//API server, so a reference only grants trust after it resolves to a
+	//live object of that kind whose UID matches the reference
+	TrustedOwners []string `json:"trustedOwners"`
+	//MetricsNamespaces lists the namespaces labeled individually on the
+	//per-namespace decision counter; decisions in any other namespace share
+	//the "other" bucket, so metric cardinality stays bounded on clusters
+	//with many namespaces
+	MetricsNamespaces []string `json:"metricsNamespaces"`
+	//DefaultSCCAnnotation names a namespace annotation (e.g.
+	//aro.openshift.io/default-scc) requesting the bootstrap SCC
+	//non-whitelisted pods of that namespace are validated against instead of
+	//restricted; empty disables the lookup
+	DefaultSCCAnnotation string `json:"defaultSCCAnnotation"`
+	//ApprovedDefaultSCCs lists the SCC names namespaces may request via the
+	//annotation; a request outside the set keeps the restricted baseline
+	ApprovedDefaultSCCs []string `json:"approvedDefaultSCCs"`
+	//MutateSafeDefaults serves a mutating /mutate endpoint next to the
+	//validating ones and registers it as a MutatingWebhookConfiguration for
+	//pods.  It patches in only defaults backed by a configured validating
+	//option (automountServiceAccountToken under
+	//requireTokenAutomountDisabled, missing requiredDropCapabilities), so a
+	//pod one unset field away from compliance is defaulted and admitted
+	//instead of denied
+	MutateSafeDefaults bool `json:"mutateSafeDefaults"`
+	//AllowedUnsafeSysctls lists sysctls (or prefix patterns ending in *)
+	//non-whitelisted pods may set in addition to the kubelet-safe set the
+	//restricted SCC already allows
+	AllowedUnsafeSysctls []string `json:"allowedUnsafeSysctls"`
+	//DecisionSinkURL, when set, receives every admission decision as a JSON
+	//POST for forwarding to a SIEM
+	DecisionSinkURL string `json:"decisionSinkURL"`
+	//DecisionSinkBufferSize bounds the decision events queued for the sink;
+	//defaults to 256 when unset
+	DecisionSinkBufferSize int `json:"decisionSinkBufferSize"`
+	//SetupTimeoutSeconds bounds how long setup waits for the controller pods
+	//to become ready before giving up and marking readiness degraded; 0
+	//means wait forever
+	SetupTimeoutSeconds int `json:"setupTimeoutSeconds"`
+	//RestrictExecToPrivileged denies pods/exec into pods running privileged
+	//containers unless the requesting user is in one of ExecPrivilegedGroups
+	RestrictExecToPrivileged bool `json:"restrictExecToPrivileged"`
+	//ExecPrivilegedGroups lists the groups still allowed to exec into
+	//privileged pods when RestrictExecToPrivileged is set
+	ExecPrivilegedGroups []string `json:"execPrivilegedGroups"`
+	//WarnOnSCCDefaults attaches a warning to allowed responses when the SCC
+	//admission will mutate defaults (e.g. runAsUser) the pod left unset
+	WarnOnSCCDefaults bool `json:"warnOnSCCDefaults"`
+	//AuditSweepOnStartup validates the workloads already running in the
+	//cluster once at startup and reports the ones which would be denied,
+	//without denying anything, so operators can whitelist proactively
+	AuditSweepOnStartup bool `json:"auditSweepOnStartup"`
+	//DecisionCacheTTLSeconds enables caching of validation decisions for
+	//identical pod specs, so API server retries and controller re-submissions
+	//do not repeat the same SCC evaluation; 0 disables the cache
+	DecisionCacheTTLSeconds int `json:"decisionCacheTTLSeconds"`
+	//HonorSkipLabel excludes pods labeled aro-admission-controller/skip=true
+	//from validation, provided the requester is allowed to use the privileged
+	//SCC; without that check customers could self-exempt by labeling pods
+	HonorSkipLabel bool `json:"honorSkipLabel"`
+	//MaxInFlightRequests bounds the number of admission reviews processed
+	//concurrently; 0 means unlimited.  Requests over the limit are answered
+	//429 immediately and retried by the API server.
+	MaxInFlightRequests int `json:"maxInFlightRequests"`
+	//ServiceAccountExemptions lists regular expressions checked against the
+	//requesting username (system:serviceaccount:<namespace>:<name>); matching
+	//users bypass the restricted validation entirely, mirroring how SCC users
+	//grant access by identity rather than by image
+	ServiceAccountExemptions []string `json:"serviceAccountExemptions"`
+	//RestrictNewSCCs forbids non-protected SCCs which allow privileged
+	//containers from being created or updated unless the requesting user is
+	//in one of NewSCCPrivilegedGroups
+	RestrictNewSCCs bool `json:"restrictNewSCCs"`
+	//NewSCCPrivilegedGroups lists the groups whose members may still create
+	//privileged-capable SCCs when RestrictNewSCCs is set
+	NewSCCPrivilegedGroups []string `json:"newSCCPrivilegedGroups"`
+	//RequiredDropCapabilities lists capabilities whitelisted pods still have
+	//to drop in securityContext.capabilities.drop, as a minimal safety floor
+	//under the whitelist
+	RequiredDropCapabilities []string `json:"requiredDropCapabilities"`
+	//ForbiddenCombinations lists combinations of privileges (e.g. privileged,
+	//hostPID, hostNetwork) which are denied even for whitelisted pods: no
+	//single flag is forbidden on its own, but a pod requesting all flags of
+	//any combination is rejected
+	ForbiddenCombinations [][]string `json:"forbiddenCombinations"`
+	//RestrictedSCCFile optionally points at a YAML or JSON file holding the
+	//baseline SCC pods are validated against, instead of the bootstrap
+	//restricted SCC
+	RestrictedSCCFile string `json:"restrictedSCCFile"`
+	//RestrictedSCCRefreshSeconds periodically re-reads the restricted SCC
+	//from the cluster, so an admin-approved change to the baseline (e.g. an
+	//adjusted uid range) is picked up without a restart.  Zero keeps the
+	//bootstrap snapshot taken at startup for the life of the process, which
+	//is the safe default: a compromised or fat-fingered cluster SCC cannot
+	//weaken the baseline
+	RestrictedSCCRefreshSeconds int `json:"restrictedSCCRefreshSeconds"`
+	//WebhookServiceName and WebhookServiceNamespace identify the service
+	//backing this webhook, for deployments outside kube-system
+	WebhookServiceName      string `json:"webhookServiceName"`
+	WebhookServiceNamespace string `json:"webhookServiceNamespace"`
+	//PrivilegedCreatorRole, PrivilegedCreatorGroup and
+	//PrivilegedCreatorBindingName override the ClusterRole, group and binding
+	//name of the ClusterRoleBinding letting customer admins create SCCs, for
+	//ARO variants using different group names; unset fields fall back to the
+	//privileged-creator/osa-customer-admins defaults
+	PrivilegedCreatorRole        string `json:"privilegedCreatorRole"`
+	PrivilegedCreatorGroup       string `json:"privilegedCreatorGroup"`
+	PrivilegedCreatorBindingName string `json:"privilegedCreatorBindingName"`
+	//LenientDecode reports objects which cannot be decoded as denials instead
+	//of HTTP 400, which FailurePolicy Fail would surface as an opaque webhook
+	//failure
+	LenientDecode bool `json:"lenientDecode"`
+	//AllowedCapabilities may be added via securityContext.capabilities.add
+	//even by non-whitelisted pods, on top of what the restricted SCC allows
+	AllowedCapabilities []string `json:"allowedCapabilities"`
+	//ValidationTimeoutSeconds bounds the API calls made while validating a
+	//single request, 0 means the default of 10 seconds.  It should stay below
+	//the webhook timeoutSeconds so the caller gets a response from us rather
+	//than a timeout from the API server.
+	ValidationTimeoutSeconds int `json:"validationTimeoutSeconds"`
+	//TLSCertFile, TLSKeyFile and ListenAddress may also be given on the
+	//command line, which takes precedence over the config file
+	TLSCertFile   string `json:"tlsCertFile"`
+	TLSKeyFile    string `json:"tlsKeyFile"`
+	ListenAddress string `json:"listenAddress"`
+	//ClientCAFile optionally points at the PEM bundle of the API server's
+	//client CA.  When set, the main listener requires and verifies a client
+	//certificate signed by it, so only the API server can submit admission
+	//reviews; the health listener is unaffected
+	ClientCAFile string `json:"clientCAFile"`
+	//HealthListenAddress optionally serves /healthz and /healthz/ready on a
+	//second listener with the same serving certificate.  Probes keep working
+	//there when the main listener starts requiring client certificates,
+	//which a kube-probe cannot present
+	HealthListenAddress string `json:"healthListenAddress"`
 }
 
-func (c *config) loadConfig() *config {
-	configFile, err := ioutil.ReadFile("/etc/aro-admission-controller/aro-admission-controller.yaml")
+// applyFlags resolves the effective TLS and listen settings.  Explicitly set
+// flags take precedence over the config file, which takes precedence over the
+// flag defaults.
+func (c *config) applyFlags(fs *flag.FlagSet) {
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	if explicit["tls-cert"] || c.TLSCertFile == "" {
+		c.TLSCertFile = fs.Lookup("tls-cert").Value.String()
+	}
+	if explicit["tls-key"] || c.TLSKeyFile == "" {
+		c.TLSKeyFile = fs.Lookup("tls-key").Value.String()
+	}
+	if explicit["listen"] || c.ListenAddress == "" {
+		c.ListenAddress = fs.Lookup("listen").Value.String()
+	}
+}
+
+// loadConfig reads the config file at path.  The ghodss parser resolves the
+// camelCase keys via the json tags, which yaml.v2 silently ignored.  An
+// absent file is tolerated with
+// an explicit warning (the controller then runs with an empty whitelist), but
+// a file which exists and cannot be read or parsed is a startup error.
+func (c *config) loadConfig(path string) error {
+	configFile, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Printf("Config file %s is absent, starting with an empty whitelist: all privileged customer workload will be denied", path)
+		return nil
+	}
 	if err != nil {
-		log.Printf("Error reading config file %s", err)
+		return fmt.Errorf("error reading config file %s: %s", path, err)
 	}
 	err = yaml.Unmarshal(configFile, c)
 	if err != nil {
-		log.Fatalf("Error unmarshalling config file %s", err)
+		return fmt.Errorf("error unmarshalling config file %s: %s", path, err)
 	}
 
-	return c
+	return nil
 }
 
+// validate reports every invalid pattern and unknown combination flag in the
+// config, not just the first one found
 func (c *config) validate() error {
-	var err error
+	var errs []error
 	for _, w := range c.Whitelist {
-		_, err = regexp.Compile(w)
+		if _, err := regexp.Compile(w); err != nil {
+			errs = append(errs, fmt.Errorf("whitelist pattern %q: %s", w, err))
+		}
 	}
-	return err
+	for _, d := range c.Denylist {
+		if _, err := regexp.Compile(d); err != nil {
+			errs = append(errs, fmt.Errorf("denylist pattern %q: %s", d, err))
+		}
+	}
+	for _, e := range c.ServiceAccountExemptions {
+		if _, err := regexp.Compile(e); err != nil {
+			errs = append(errs, fmt.Errorf("serviceAccountExemptions pattern %q: %s", e, err))
+		}
+	}
+	for _, combination := range c.ForbiddenCombinations {
+		for _, f := range combination {
+			if _, found := forbiddenCombinationChecks[f]; !found {
+				errs = append(errs, fmt.Errorf("unknown flag %q in forbiddenCombinations", f))
+			}
+		}
+	}
+	return errors.NewAggregate(errs)
 }
 
-// imageIsWhitelisted returns true if the image matches any whitelistedImages
-// regular expression
+// validateConfigFile lints the config file without starting the server, so
+// admins can verify a rollout before deploying it.  Every accepted rule is
+// printed; invalid entries are all reported at once.
+func validateConfigFile(path string, out io.Writer) error {
+	var c config
+	err := c.loadConfig(path)
+	if err != nil {
+		return err
+	}
+	err = c.validate()
+	if err != nil {
+		return err
+	}
+	for _, w := range c.Whitelist {
+		fmt.Fprintf(out, "whitelist: %s\n", w)
+	}
+	for _, d := range c.Denylist {
+		fmt.Fprintf(out, "denylist: %s\n", d)
+	}
+	for _, e := range c.ServiceAccountExemptions {
+		fmt.Fprintf(out, "serviceAccountExemption: %s\n", e)
+	}
+	for _, combination := range c.ForbiddenCombinations {
+		fmt.Fprintf(out, "forbiddenCombination: %v\n", combination)
+	}
+	return nil
+}
 
 type admissionController struct {
-	client            internalclientset.Interface
-	restricted        *security.SecurityContextConstraints
+	client internalclientset.Interface
+	//securityClient reaches the cluster's SCCs; it is only set when the
+	//restricted baseline is refreshed on a timer and is nil otherwise
+	securityClient securityinternalclientset.Interface
+	//ownerClient resolves ownerReferences of arbitrary kinds (including
+	//CRDs) so trustedOwner can verify them; it is only set when
+	//trustedOwners is configured and is nil otherwise
+	ownerClient dynamic.Interface
+
+	//restrictedLock guards restricted, which refreshRestrictedSCC can swap
+	//for the live cluster SCC; read it through restrictedSCC()
+	restrictedLock sync.RWMutex
+	restricted     *security.SecurityContextConstraints
+
+	//policyLock guards the reloadable policy fields below, which reloadConfig
+	//swaps in atomically on SIGHUP
+	policyLock        sync.RWMutex
 	whitelistedImages []*regexp.Regexp
+	denylistedImages  []*regexp.Regexp
+	exemptedUsers     []*regexp.Regexp
 	protectedSCCs     map[string]security.SecurityContextConstraints
+
+	//loadedConfig keeps the reloadable sections of the last applied config,
+	//so reloadConfig can recompute only what changed
+	loadedConfig *config
+
+	rejectHostAliases                  bool
+	requireTokenAutomountDisabled      bool
+	protectedSCCFiles                  []string
+	maxRequestBytesLimit               int64
+	denialMessagePrefix                string
+	denialMessageSuffix                string
+	requireFullyQualifiedImages        bool
+	validationTimeoutSeconds           int
+	lenientDecode                      bool
+	webhookServiceName                 string
+	webhookServiceNamespace            string
+	privilegedCreatorRole              string
+	privilegedCreatorGroup             string
+	privilegedCreatorBindingName       string
+	forbiddenCombinations              [][]string
+	requiredDropCapabilities           []string
+	restrictNewSCCs                    bool
+	newSCCPrivilegedGroups             []string
+	maxInFlightRequests                int
+	honorSkipLabel                     bool
+	decisionCacheTTLSeconds            int
+	auditSweepOnStartup                bool
+	warnOnSCCDefaults                  bool
+	restrictExecToPrivileged           bool
+	execPrivilegedGroups               []string
+	signatureVerifier                  imageSignatureVerifier
+	setupTimeoutSeconds                int
+	setupDegraded                      int64
+	rejectPodOverhead                  bool
+	decisionSinkURL                    string
+	decisionSink                       chan decisionEvent
+	reservedResources                  []string
+	allowedUnsafeSysctls               []string
+	validateNodeSelectorInitContainers bool
+	trustedOwners                      []string
+	whitelistProfiles                  map[string]whitelistProfile
+	honorPrivilegedSCCBindings         bool
+	verboseSCCLogging                  bool
+	mutateSafeDefaults                 bool
+	defaultSCCAnnotation               string
+	approvedDefaultSCCs                map[string]bool
+	allowedCapabilities                []string
+	restrictedSCCRefreshSeconds        int
+	auditDeniedWorkloads               int64
+
+	decisionCacheLock sync.Mutex
+	decisionCache     map[string]decisionCacheEntry
+	inFlightSemaphore chan struct{}
+	inFlightRequests  int64
+
+	sccTamperLock     sync.Mutex
+	sccTamperAttempts map[sccTamperKey]int64
+
+	metricsNamespaces map[string]bool
+	decisionCountLock sync.Mutex
+	decisionCounts    map[decisionKey]int64
+
+	tlsCertFile         string
+	tlsKeyFile          string
+	clientCAFile        string
+	listenAddress       string
+	healthListenAddress string
 }
 
-func (ac *admissionController) run() error {
+// NewAdmissionController assembles an admissionController from the given
+// config, compiling the image whitelist and denylist and initializing the
+// protected SCC templates.  Invalid patterns or templates are reported as
+// errors instead of panicking.
+func NewAdmissionController(c *config, client internalclientset.Interface, restricted *security.SecurityContextConstraints) (*admissionController, error) {
+	ac := &admissionController{
+		client:     client,
+		restricted: restricted,
+
+		rejectHostAliases:                  c.RejectHostAliases,
+		requireTokenAutomountDisabled:      c.RequireTokenAutomountDisabled,
+		protectedSCCFiles:                  c.ProtectedSCCFiles,
+		maxRequestBytesLimit:               c.MaxRequestBytes,
+		denialMessagePrefix:                c.DenialMessagePrefix,
+		denialMessageSuffix:                c.DenialMessageSuffix,
+		requireFullyQualifiedImages:        c.RequireFullyQualifiedImages,
+		validationTimeoutSeconds:           c.ValidationTimeoutSeconds,
+		lenientDecode:                      c.LenientDecode,
+		webhookServiceName:                 c.WebhookServiceName,
+		webhookServiceNamespace:            c.WebhookServiceNamespace,
+		privilegedCreatorRole:              c.PrivilegedCreatorRole,
+		privilegedCreatorGroup:             c.PrivilegedCreatorGroup,
+		privilegedCreatorBindingName:       c.PrivilegedCreatorBindingName,
+		forbiddenCombinations:              c.ForbiddenCombinations,
+		requiredDropCapabilities:           c.RequiredDropCapabilities,
+		restrictNewSCCs:                    c.RestrictNewSCCs,
+		newSCCPrivilegedGroups:             c.NewSCCPrivilegedGroups,
+		maxInFlightRequests:                c.MaxInFlightRequests,
+		honorSkipLabel:                     c.HonorSkipLabel,
+		decisionCacheTTLSeconds:            c.DecisionCacheTTLSeconds,
+		auditSweepOnStartup:                c.AuditSweepOnStartup,
+		warnOnSCCDefaults:                  c.WarnOnSCCDefaults,
+		restrictExecToPrivileged:           c.RestrictExecToPrivileged,
+		execPrivilegedGroups:               c.ExecPrivilegedGroups,
+		setupTimeoutSeconds:                c.SetupTimeoutSeconds,
+		rejectPodOverhead:                  c.RejectPodOverhead,
+		decisionSinkURL:                    c.DecisionSinkURL,
+		reservedResources:                  c.ReservedResources,
+		allowedUnsafeSysctls:               c.AllowedUnsafeSysctls,
+		validateNodeSelectorInitContainers: c.ValidateNodeSelectorInitContainers,
+		trustedOwners:                      c.TrustedOwners,
+		whitelistProfiles:                  c.WhitelistProfiles,
+		honorPrivilegedSCCBindings:         c.HonorPrivilegedSCCBindings,
+		verboseSCCLogging:                  c.VerboseSCCLogging,
+		mutateSafeDefaults:                 c.MutateSafeDefaults,
+		defaultSCCAnnotation:               c.DefaultSCCAnnotation,
+		allowedCapabilities:                c.AllowedCapabilities,
+		restrictedSCCRefreshSeconds:        c.RestrictedSCCRefreshSeconds,
+
+		tlsCertFile:         c.TLSCertFile,
+		tlsKeyFile:          c.TLSKeyFile,
+		clientCAFile:        c.ClientCAFile,
+		listenAddress:       c.ListenAddress,
+		healthListenAddress: c.HealthListenAddress,
+	}
+	ac.restricted = extendRestrictedSCC(restricted, c.AllowedCapabilities)
+	for _, combination := range c.ForbiddenCombinations {
+		for _, flag := range combination {
+			if _, found := forbiddenCombinationChecks[flag]; !found {
+				return nil, fmt.Errorf("unknown flag %q in forbiddenCombinations", flag)
+			}
+		}
+	}
+	var err error
+	ac.whitelistedImages, err = compilePatterns("whitelist", c.Whitelist)
+	if err != nil {
+		return nil, err
+	}
+	ac.denylistedImages, err = compilePatterns("denylist", c.Denylist)
+	if err != nil {
+		return nil, err
+	}
+	ac.exemptedUsers, err = compilePatterns("serviceAccountExemptions", c.ServiceAccountExemptions)
+	if err != nil {
+		return nil, err
+	}
+	if c.MaxInFlightRequests > 0 {
+		ac.inFlightSemaphore = make(chan struct{}, c.MaxInFlightRequests)
+	}
+	if c.DecisionCacheTTLSeconds > 0 {
+		ac.decisionCache = map[string]decisionCacheEntry{}
+	}
+	if len(c.ApprovedDefaultSCCs) > 0 {
+		ac.approvedDefaultSCCs = map[string]bool{}
+		for _, name := range c.ApprovedDefaultSCCs {
+			ac.approvedDefaultSCCs[name] = true
+		}
+	}
+	if len(c.MetricsNamespaces) > 0 {
+		ac.metricsNamespaces = map[string]bool{}
+		for _, namespace := range c.MetricsNamespaces {
+			ac.metricsNamespaces[namespace] = true
+		}
+	}
+	if c.DecisionSinkURL != "" {
+		buffer := c.DecisionSinkBufferSize
+		if buffer <= 0 {
+			buffer = defaultDecisionSinkBuffer
+		}
+		ac.decisionSink = make(chan decisionEvent, buffer)
+	}
 	ac.protectedSCCs = ac.InitProtectedSCCs()
+	err = ac.mergeProtectedSCCTemplates(ac.protectedSCCFiles)
+	if err != nil {
+		return nil, err
+	}
+	ac.loadedConfig = c
+	return ac, nil
+}
+
+// compilePatterns compiles a config section of regular expressions, naming
+// the section and the broken entry on error.
+func compilePatterns(section string, patterns []string) ([]*regexp.Regexp, error) {
+	var rxs []*regexp.Regexp
+	for _, p := range patterns {
+		rx, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling %s pattern %q: %s", section, p, err)
+		}
+		rxs = append(rxs, rx)
+	}
+	return rxs, nil
+}
+
+// imagePolicies returns the current whitelist and denylist under the policy
+// lock
+func (ac *admissionController) imagePolicies() ([]*regexp.Regexp, []*regexp.Regexp) {
+	ac.policyLock.RLock()
+	defer ac.policyLock.RUnlock()
+	return ac.whitelistedImages, ac.denylistedImages
+}
+
+// profilePolicies returns the current whitelist profiles under the policy
+// lock
+func (ac *admissionController) profilePolicies() map[string]whitelistProfile {
+	ac.policyLock.RLock()
+	defer ac.policyLock.RUnlock()
+	return ac.whitelistProfiles
+}
+
+// userIsExempted reports whether the requesting username matches one of the
+// configured service account exemption patterns, together with the pattern
+// which matched
+func (ac *admissionController) userIsExempted(username string) (string, bool) {
+	if username == "" {
+		return "", false
+	}
+	ac.policyLock.RLock()
+	defer ac.policyLock.RUnlock()
+	for _, rx := range ac.exemptedUsers {
+		if rx.MatchString(username) {
+			return rx.String(), true
+		}
+	}
+	return "", false
+}
+
+// protectedSCCTemplate returns the protected template for the named SCC under
+// the policy lock
+func (ac *admissionController) protectedSCCTemplate(name string) (security.SecurityContextConstraints, bool) {
+	ac.policyLock.RLock()
+	defer ac.policyLock.RUnlock()
+	template, found := ac.protectedSCCs[name]
+	return template, found
+}
+
+// reloadConfig re-reads the config file and swaps the whitelist, denylist,
+// whitelist profiles and protected SCC templates in atomically, so operators can roll out policy
+// changes without a rolling restart.  On any error the previous policy stays
+// active.
+func (ac *admissionController) reloadConfig(path string) error {
+	var c config
+	err := c.loadConfig(path)
+	if err != nil {
+		return err
+	}
+	last := ac.loadedConfig
+	if last == nil {
+		last = &config{}
+	}
+	//recompute only the sections which changed, so a frequent whitelist edit
+	//does not rebuild the protected SCC map, and a broken section leaves the
+	//others untouched
+	whitelistChanged := !reflect.DeepEqual(c.Whitelist, last.Whitelist)
+	denylistChanged := !reflect.DeepEqual(c.Denylist, last.Denylist)
+	profilesChanged := !reflect.DeepEqual(c.WhitelistProfiles, last.WhitelistProfiles)
+	exemptionsChanged := !reflect.DeepEqual(c.ServiceAccountExemptions, last.ServiceAccountExemptions)
+	protectedChanged := !reflect.DeepEqual(c.ProtectedSCCFiles, last.ProtectedSCCFiles)
+
+	var whitelistedImages, denylistedImages, exemptedUsers []*regexp.Regexp
+	if whitelistChanged {
+		whitelistedImages, err = compilePatterns("whitelist", c.Whitelist)
+		if err != nil {
+			return err
+		}
+	}
+	if denylistChanged {
+		denylistedImages, err = compilePatterns("denylist", c.Denylist)
+		if err != nil {
+			return err
+		}
+	}
+	if exemptionsChanged {
+		exemptedUsers, err = compilePatterns("serviceAccountExemptions", c.ServiceAccountExemptions)
+		if err != nil {
+			return err
+		}
+	}
+	var protectedSCCs map[string]security.SecurityContextConstraints
+	if protectedChanged {
+		//rebuild on a scratch controller so a broken template file leaves
+		//the served set untouched
+		scratch := &admissionController{}
+		scratch.protectedSCCs = scratch.InitProtectedSCCs()
+		err = scratch.mergeProtectedSCCTemplates(c.ProtectedSCCFiles)
+		if err != nil {
+			return err
+		}
+		protectedSCCs = scratch.protectedSCCs
+	}
+
+	var changed []string
+	ac.policyLock.Lock()
+	if whitelistChanged {
+		ac.whitelistedImages = whitelistedImages
+		changed = append(changed, "whitelist")
+	}
+	if denylistChanged {
+		ac.denylistedImages = denylistedImages
+		changed = append(changed, "denylist")
+	}
+	if profilesChanged {
+		ac.whitelistProfiles = c.WhitelistProfiles
+		changed = append(changed, "whitelistProfiles")
+	}
+	if exemptionsChanged {
+		ac.exemptedUsers = exemptedUsers
+		changed = append(changed, "serviceAccountExemptions")
+	}
+	if protectedChanged {
+		ac.protectedSCCs = protectedSCCs
+		ac.protectedSCCFiles = c.ProtectedSCCFiles
+		changed = append(changed, "protectedSCCs")
+	}
+	ac.policyLock.Unlock()
+	ac.loadedConfig = &c
+
+	if len(changed) == 0 {
+		log.Printf("Config reloaded from %s: no policy sections changed", path)
+		return nil
+	}
+	if whitelistChanged || denylistChanged || profilesChanged {
+		//decisions cached before the reload may rest on the previous
+		//whitelist or profiles
+		ac.purgeDecisionCache()
+	}
+	log.Printf("Config reloaded from %s: recomputed sections %v", path, changed)
+	return nil
+}
+
+// watchSIGHUP installs the signal handler triggering config reloads
+func (ac *admissionController) watchSIGHUP(path string) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		for range ch {
+			err := ac.reloadConfig(path)
+			if err != nil {
+				log.Printf("Error reloading config, keeping the previous one: %s", err)
+			}
+		}
+	}()
+}
+
+const defaultMaxRequestBytes = int64(4 << 20)
+
+// validationTimeout returns the configured per-request validation deadline,
+// falling back to the default when unconfigured
+func (ac *admissionController) validationTimeout() time.Duration {
+	if ac.validationTimeoutSeconds > 0 {
+		return time.Duration(ac.validationTimeoutSeconds) * time.Second
+	}
+	return 10 * time.Second
+}
+
+// maxRequestBytes returns the configured request body size limit, falling back
+// to the default when unconfigured
+func (ac *admissionController) maxRequestBytes() int64 {
+	if ac.maxRequestBytesLimit > 0 {
+		return ac.maxRequestBytesLimit
+	}
+	return defaultMaxRequestBytes
+}
+
+func (ac *admissionController) setupHandlers() *http.ServeMux {
 	mux := &http.ServeMux{}
-	mux.HandleFunc("/pods", ac.handleWhitelist)
-	mux.HandleFunc("/daemonsets", ac.handleWhitelist)
-	mux.HandleFunc("/replicasets", ac.handleWhitelist)
-	mux.HandleFunc("/statefulsets", ac.handleWhitelist)
-	mux.HandleFunc("/jobs", ac.handleWhitelist)
-	mux.HandleFunc("/cronjobs", ac.handleWhitelist)
-	mux.HandleFunc("/deploymentconfigs", ac.handleWhitelist)
-	mux.HandleFunc("/deployments", ac.handleWhitelist)
-	mux.HandleFunc("/sccs", ac.handleSCC)
+	//the review endpoints share the in-flight limiter; health, version and
+	//metrics stay outside it so they keep answering under load
+	mux.HandleFunc("/pods", ac.limitInFlight(ac.handleWhitelist))
+	mux.HandleFunc("/podsexec", ac.limitInFlight(ac.handlePodExec))
+	mux.HandleFunc("/replicationcontrollers", ac.limitInFlight(ac.handleWhitelist))
+	mux.HandleFunc("/daemonsets", ac.limitInFlight(ac.handleWhitelist))
+	mux.HandleFunc("/replicasets", ac.limitInFlight(ac.handleWhitelist))
+	mux.HandleFunc("/statefulsets", ac.limitInFlight(ac.handleWhitelist))
+	mux.HandleFunc("/jobs", ac.limitInFlight(ac.handleWhitelist))
+	mux.HandleFunc("/cronjobs", ac.limitInFlight(ac.handleWhitelist))
+	mux.HandleFunc("/deploymentconfigs", ac.limitInFlight(ac.handleWhitelist))
+	mux.HandleFunc("/deployments", ac.limitInFlight(ac.handleWhitelist))
+	mux.HandleFunc("/validate", ac.limitInFlight(ac.handleValidate))
+	if ac.mutateSafeDefaults {
+		mux.HandleFunc("/mutate", ac.limitInFlight(ac.handleMutate))
+	}
+	mux.HandleFunc("/securitycontextconstraints", ac.limitInFlight(ac.handleSCC))
+	//deprecated path, kept for compatibility with old webhook configurations
+	mux.HandleFunc("/sccs", ac.limitInFlight(ac.handleSCC))
 
 	mux.HandleFunc("/healthz", ac.handleHealthz)
-	mux.HandleFunc("/healthz/ready", ac.handleHealthz)
+	mux.HandleFunc("/healthz/ready", ac.handleHealthzReady)
+	mux.HandleFunc("/version", ac.handleVersion)
+	mux.HandleFunc("/metrics", ac.handleMetrics)
+	mux.HandleFunc("/debug/config", ac.handleDebugConfig)
+	mux.HandleFunc("/explain", ac.handleExplain)
+
+	return mux
+}
+
+// serverTLSConfig returns the TLS configuration of the main listener.  With
+// a client CA configured it requires and verifies a client certificate, so
+// only callers holding a certificate signed by the API server's client CA
+// can submit admission reviews; without one it returns nil and the listener
+// accepts any TLS client, as before.
+func (ac *admissionController) serverTLSConfig() (*tls.Config, error) {
+	if ac.clientCAFile == "" {
+		return nil, nil
+	}
+	caBundle, err := ioutil.ReadFile(ac.clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading client CA bundle: %s", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caBundle) {
+		return nil, fmt.Errorf("no client CA certificates found in %s", ac.clientCAFile)
+	}
+	return &tls.Config{
+		ClientCAs:  pool,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	}, nil
+}
+
+// healthHandlers returns a mux carrying only the health endpoints, for the
+// separate health listener.  The admission, metrics and debug paths stay on
+// the main listener, so tightening it (e.g. with client certificates) never
+// exposes them unauthenticated here.
+func (ac *admissionController) healthHandlers() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", ac.handleHealthz)
+	mux.HandleFunc("/healthz/ready", ac.handleHealthzReady)
+	return mux
+}
+
+// Handler returns the controller's configured http.Handler.  It decouples
+// the routing from ListenAndServeTLS, so integration tests can serve the
+// controller from an httptest.Server without TLS certificate files.
+func (ac *admissionController) Handler() http.Handler {
+	return ac.setupHandlers()
+}
+
+func (ac *admissionController) run() error {
+	mux := ac.setupHandlers()
+
+	logDecodableKinds()
+
+	go ac.setupAdmissionController()
+	if ac.auditSweepOnStartup {
+		go ac.auditSweep()
+	}
+	if ac.decisionSink != nil {
+		go ac.forwardDecisions()
+	}
+	if ac.restrictedSCCRefreshSeconds > 0 && ac.securityClient != nil {
+		go ac.refreshRestrictedSCC()
+	}
+
+	if ac.healthListenAddress != "" {
+		go func() {
+			err := http.ListenAndServeTLS(ac.healthListenAddress, ac.tlsCertFile, ac.tlsKeyFile, ac.healthHandlers())
+			if err != nil {
+				log.Fatal("health ListenAndServeTLS: ", err)
+			}
+		}()
+	}
+
+	tlsConfig, err := ac.serverTLSConfig()
+	if err != nil {
+		return err
+	}
 
-	log.Print("Aro Admission Controller starting.")
-	err := http.ListenAndServeTLS(":8443", "/etc/aro-admission-controller/aro-admission-controller.crt", "/etc/aro-admission-controller/aro-admission-controller.key", mux)
+	log.Printf("Aro Admission Controller starting. version %s, commit %s, built %s", version, gitCommit, buildDate)
+	server := &http.Server{
+		Addr:      ac.listenAddress,
+		Handler:   mux,
+		TLSConfig: tlsConfig,
+	}
+	err = server.ListenAndServeTLS(ac.tlsCertFile, ac.tlsKeyFile)
 	if err != nil {
 		log.Fatal("ListenAndServeTLS: ", err)
 	}
@@ -151,31 +927,121 @@ func (ac *admissionController) handleHealthz(w http.ResponseWriter, r *http.Requ
 	return
 }
 
-func getRestrictedSCC() (*security.SecurityContextConstraints, error) {
-	var restricted *security.SecurityContextConstraints
+// handleHealthzReady reports readiness.  It fails once setup has given up
+// waiting for the controller pods, so a bad deploy surfaces as a degraded
+// endpoint instead of a silently unregistered webhook.
+func (ac *admissionController) handleHealthzReady(w http.ResponseWriter, r *http.Request) {
+	if ac.restrictedSCC() == nil {
+		http.Error(w, "no restricted SCC is loaded to validate against", http.StatusServiceUnavailable)
+		return
+	}
+	if atomic.LoadInt64(&ac.setupDegraded) == 1 {
+		http.Error(w, "setup timed out waiting for aro-admission-controller pods", http.StatusServiceUnavailable)
+	}
+}
+
+// extendRestrictedSCC returns a copy of the given SCC with the configured
+// allowedCapabilities added, so narrowly scoped capabilities (e.g.
+// NET_BIND_SERVICE) can be granted to non-whitelisted pods without
+// whitelisting their images; anything outside the configured set is still
+// denied by the SCC validation.  It is applied to the bootstrap snapshot and
+// to every refreshed baseline alike.
+func extendRestrictedSCC(restricted *security.SecurityContextConstraints, capabilities []string) *security.SecurityContextConstraints {
+	if len(capabilities) == 0 || restricted == nil {
+		return restricted
+	}
+	extended := restricted.DeepCopy()
+	for _, capability := range capabilities {
+		extended.AllowedCapabilities = append(extended.AllowedCapabilities, core.Capability(capability))
+	}
+	return extended
+}
+
+// restrictedSCC returns the current restricted baseline.  All readers go
+// through it so refreshRestrictedSCC can swap the baseline without racing
+// in-flight validations.
+func (ac *admissionController) restrictedSCC() *security.SecurityContextConstraints {
+	ac.restrictedLock.RLock()
+	defer ac.restrictedLock.RUnlock()
+	return ac.restricted
+}
+
+func (ac *admissionController) setRestrictedSCC(restricted *security.SecurityContextConstraints) {
+	restricted = extendRestrictedSCC(restricted, ac.allowedCapabilities)
+	ac.restrictedLock.Lock()
+	previous := ac.restricted
+	ac.restricted = restricted
+	ac.restrictedLock.Unlock()
+	if !reflect.DeepEqual(previous, restricted) {
+		//decisions cached before the swap may rest on the previous baseline
+		ac.purgeDecisionCache()
+	}
+}
+
+// refreshRestrictedSCCOnce replaces the restricted baseline with the SCC of
+// the same name currently in the cluster.
+func (ac *admissionController) refreshRestrictedSCCOnce() error {
+	current := ac.restrictedSCC()
+	if current == nil {
+		return fmt.Errorf("no restricted SCC is loaded to refresh")
+	}
+	scc, err := ac.securityClient.Security().SecurityContextConstraints().Get(current.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	ac.setRestrictedSCC(scc)
+	return nil
+}
+
+// refreshRestrictedSCC re-reads the restricted SCC from the cluster on the
+// configured interval, so an admin-approved change to the baseline is picked
+// up without a restart.  A failed read keeps the current baseline: validating
+// against a stale SCC beats not validating at all.
+func (ac *admissionController) refreshRestrictedSCC() {
+	ticker := time.NewTicker(time.Duration(ac.restrictedSCCRefreshSeconds) * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := ac.refreshRestrictedSCCOnce(); err != nil {
+			log.Printf("Error refreshing the restricted SCC, keeping the current baseline: %s", err)
+		}
+	}
+}
 
+// getBootstrapSCC returns the named SCC from the bootstrap policy.
+func getBootstrapSCC(name string) (*security.SecurityContextConstraints, error) {
 	groups, users := bootstrappolicy.GetBoostrapSCCAccess(bootstrappolicy.DefaultOpenShiftInfraNamespace)
 	for _, scc := range bootstrappolicy.GetBootstrapSecurityContextConstraints(groups, users) {
-		if scc.Name == bootstrappolicy.SecurityContextConstraintRestricted {
-			restricted = scc
+		if scc.Name == name {
+			return scc, nil
 		}
 	}
-	if restricted == nil {
-		return nil, fmt.Errorf("couldn't find restricted SCC in bootstrappolicy")
-	}
+	return nil, fmt.Errorf("couldn't find %s SCC in bootstrappolicy", name)
+}
 
-	return restricted, nil
+func getRestrictedSCC() (*security.SecurityContextConstraints, error) {
+	return getBootstrapSCC(bootstrappolicy.SecurityContextConstraintRestricted)
 }
 
-func run() error {
-	// TODO: read TLS certificates and whitelist from a config file
+func run(fs *flag.FlagSet) error {
+	if fs.Lookup("validate-config").Value.String() == "true" {
+		return validateConfigFile(fs.Lookup("config").Value.String(), os.Stdout)
+	}
+	if fs.Lookup("gen-webhook-config").Value.String() == "true" {
+		return genWebhookConfigFile(fs.Lookup("config").Value.String(), os.Stdout)
+	}
+
 	var c config
-	var whitelistedImages = []*regexp.Regexp{}
-	c.loadConfig()
-	for _, w := range c.Whitelist {
-		whitelistedImages = append(whitelistedImages, regexp.MustCompile(w))
+	err := c.loadConfig(fs.Lookup("config").Value.String())
+	if err != nil {
+		return err
+	}
+	c.applyFlags(fs)
+	var restricted *security.SecurityContextConstraints
+	if c.RestrictedSCCFile != "" {
+		restricted, err = loadRestrictedSCC(c.RestrictedSCCFile)
+	} else {
+		restricted, err = getRestrictedSCC()
 	}
-	restricted, err := getRestrictedSCC()
 	if err != nil {
 		return err
 	}
@@ -198,16 +1064,30 @@ func run() error {
 		return err
 	}
 
-	ac := &admissionController{
-		client:            client,
-		restricted:        restricted,
-		whitelistedImages: whitelistedImages,
+	ac, err := NewAdmissionController(&c, client, restricted)
+	if err != nil {
+		return err
+	}
+	if c.RestrictedSCCRefreshSeconds > 0 {
+		ac.securityClient, err = securityinternalclientset.NewForConfig(restconfig)
+		if err != nil {
+			return err
+		}
+	}
+	if len(c.TrustedOwners) > 0 {
+		ac.ownerClient, err = dynamic.NewForConfig(restconfig)
+		if err != nil {
+			return err
+		}
 	}
+	ac.watchSIGHUP(fs.Lookup("config").Value.String())
 
 	return ac.run()
 }
 func main() {
-	if err := run(); err != nil {
+	registerFlags(flag.CommandLine)
+	flag.Parse()
+	if err := run(flag.CommandLine); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -2,14 +2,26 @@ package main
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
+	"flag"
 	"fmt"
 	"io/ioutil"
+	"math/big"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"reflect"
-	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	_ "github.com/openshift/origin/pkg/api/install"
 	_ "github.com/openshift/origin/pkg/security/apis/security/install"
@@ -65,9 +77,9 @@ func TestHandleMalformedRequests(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	ac := &admissionController{
-		client:     client,
-		restricted: restricted,
+	ac, err := NewAdmissionController(&config{}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
 	}
 
 	pod, err := json.Marshal(&corev1.Pod{
@@ -191,6 +203,36 @@ func TestHandleMalformedRequests(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "empty JSON object body",
+			request: &http.Request{
+				Method: http.MethodPost,
+				Header: http.Header{"Content-Type": []string{"application/json"}},
+				Body:   ioutil.NopCloser(bytes.NewReader([]byte("{}"))),
+			},
+			response: &fakeResponseWriter{
+				statusCode: 400,
+				h: http.Header{
+					"X-Content-Type-Options": []string{"nosniff"},
+					"Content-Type":           []string{"text/plain; charset=utf-8"},
+				},
+			},
+		},
+		{
+			name: "JSON null body",
+			request: &http.Request{
+				Method: http.MethodPost,
+				Header: http.Header{"Content-Type": []string{"application/json"}},
+				Body:   ioutil.NopCloser(bytes.NewReader([]byte("null"))),
+			},
+			response: &fakeResponseWriter{
+				statusCode: 400,
+				h: http.Header{
+					"X-Content-Type-Options": []string{"nosniff"},
+					"Content-Type":           []string{"text/plain; charset=utf-8"},
+				},
+			},
+		},
 		{
 			name: "no UID",
 			request: &http.Request{
@@ -222,6 +264,29 @@ func TestHandleMalformedRequests(t *testing.T) {
 			},
 		},
 		{
+			name: "empty Object.Raw",
+			request: &http.Request{
+				Method: http.MethodPost,
+				Header: http.Header{"Content-Type": []string{"application/json"}},
+				Body: ioutil.NopCloser(bytes.NewReader([]byte(`{
+					"request": {
+						"uid": "uid",
+						"kind": {"version": "v1", "kind": "Pod"},
+						"resource": {"version": "v1", "resource": "pods"}
+					}
+				}`))),
+			},
+			response: &fakeResponseWriter{
+				statusCode: 400,
+				h: http.Header{
+					"X-Content-Type-Options": []string{"nosniff"},
+					"Content-Type":           []string{"text/plain; charset=utf-8"},
+				},
+			},
+		},
+		{
+			//a DaemonSet without any pod template decodes cleanly but carries
+			//nothing to validate, so it is rejected rather than allowed
 			name: "wrong version, kind, good content",
 			request: &http.Request{
 				Method: http.MethodPost,
@@ -229,9 +294,10 @@ func TestHandleMalformedRequests(t *testing.T) {
 				Body:   ioutil.NopCloser(bytes.NewReader(reqWrongContent)),
 			},
 			response: &fakeResponseWriter{
-				statusCode: 200,
+				statusCode: 400,
 				h: http.Header{
-					"Content-Type": []string{"application/json"},
+					"X-Content-Type-Options": []string{"nosniff"},
+					"Content-Type":           []string{"text/plain; charset=utf-8"},
 				},
 			},
 		},
@@ -266,20 +332,18 @@ func TestHandleWhitelistHappyPath(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	var whitelistedImages = []*regexp.Regexp{
-		regexp.MustCompile("^whitelistedimage1$"),
-		regexp.MustCompile("^whitelistedimage2$"),
-	}
-	ac := &admissionController{
-		client:            client,
-		restricted:        restricted,
-		whitelistedImages: whitelistedImages,
+	ac, err := NewAdmissionController(&config{
+		Whitelist: []string{"^whitelistedimage1$", "^whitelistedimage2$"},
+	}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
 	}
 
 	for _, test := range []struct {
-		name     string
-		podSpec  corev1.PodSpec
-		response *admissionv1beta1.AdmissionResponse
+		name        string
+		podSpec     corev1.PodSpec
+		response    *admissionv1beta1.AdmissionResponse
+		annotations map[string]string
 	}{
 		{
 			name: "regular non-privileged image, allow",
@@ -303,6 +367,7 @@ func TestHandleWhitelistHappyPath(t *testing.T) {
 			podSpec: corev1.PodSpec{
 				Containers: []corev1.Container{
 					{
+						Name:  "mycontainer",
 						Image: "regularimage",
 						SecurityContext: &corev1.SecurityContext{
 							Privileged: &[]bool{true}[0],
@@ -315,7 +380,22 @@ func TestHandleWhitelistHappyPath(t *testing.T) {
 				Allowed: false,
 				Result: &metav1.Status{
 					Status:  metav1.StatusFailure,
-					Message: "spec.containers[0].securityContext.privileged: Invalid value: true: Privileged containers are not allowed",
+					Message: "[spec.containers[0].securityContext.privileged: Invalid value: true: validated against SCC \"restricted\": Privileged containers are not allowed, spec: Forbidden: containers [mycontainer] use images which are not whitelisted]",
+					Reason:  reasonPrivilegedNotWhitelisted,
+					Details: &metav1.StatusDetails{
+						Causes: []metav1.StatusCause{
+							{
+								Type:    metav1.CauseTypeFieldValueInvalid,
+								Field:   "spec.containers[0].securityContext.privileged",
+								Message: "Invalid value: true: validated against SCC \"restricted\": Privileged containers are not allowed",
+							},
+							{
+								Type:    metav1.CauseType("FieldValueForbidden"),
+								Field:   "spec",
+								Message: "Forbidden: containers [mycontainer] use images which are not whitelisted",
+							},
+						},
+					},
 				},
 			},
 		},
@@ -335,6 +415,9 @@ func TestHandleWhitelistHappyPath(t *testing.T) {
 					Status: metav1.StatusSuccess,
 				},
 			},
+			annotations: map[string]string{
+				"aro-admission-controller/matched-rule": "^whitelistedimage1$",
+			},
 		},
 		{
 			name: "whitelisted privileged image, allow",
@@ -355,6 +438,9 @@ func TestHandleWhitelistHappyPath(t *testing.T) {
 					Status: metav1.StatusSuccess,
 				},
 			},
+			annotations: map[string]string{
+				"aro-admission-controller/matched-rule": "^whitelistedimage1$",
+			},
 		},
 		{
 			name: "regular privileged image, annotated with master node selector, allow",
@@ -489,23 +575,198 @@ func TestHandleWhitelistHappyPath(t *testing.T) {
 			if w.statusCode != 200 {
 				t.Errorf("got status code %d, %s", w.statusCode, w.Buffer.String())
 			}
-			if !reflect.DeepEqual(w.Header(), http.Header{"Content-Type": []string{"application/json"}}) {
+			if !reflect.DeepEqual(w.Header(), http.Header{"Content-Type": []string{"application/json"}, requestUIDHeader: []string{"uid"}}) {
 				t.Errorf("got header %#v", w.Header())
 			}
 
-			var rev *admissionv1beta1.AdmissionReview
+			var rev *admissionReview
 			err = json.NewDecoder(w).Decode(&rev)
 			if err != nil {
 				t.Fatal(err)
 			}
 
-			if !reflect.DeepEqual(rev.Response, test.response) {
+			if !reflect.DeepEqual(rev.Response, withDecisionAnnotations(test.response, test.annotations)) {
 				t.Errorf("got respose %#v", rev.Response)
 			}
 		})
 	}
 }
 
+func TestHandleSCCCreate(t *testing.T) {
+	ac, err := NewAdmissionController(&config{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name     string
+		scc      string
+		response *admissionv1beta1.AdmissionResponse
+	}{
+		{
+			name: "recreate protected SCC with altered fields, forbid",
+			scc: `{
+				"allowHostIPC": false,
+				"allowHostNetwork": false,
+				"allowHostPID": false,
+				"allowHostPorts": false,
+				"allowPrivilegeEscalation": true,
+				"allowPrivilegedContainer": true,
+				"fSGroup": {
+					"type": "RunAsAny"
+				},
+				"groups": [
+					"system:cluster-admins"
+				],
+				"metadata": {
+					"creationTimestamp": null,
+					"name": "anyuid",
+					"labels": {
+						"azure.openshift.io/owned-by-sync-pod": "true"
+					}
+				},
+				"priority": 10,
+				"readOnlyRootFilesystem": false,
+				"requiredDropCapabilities": [
+					"MKNOD"
+				],
+				"runAsUser": {
+					"type": "RunAsAny"
+				},
+				"seLinuxContext": {
+					"type": "MustRunAs"
+				},
+				"supplementalGroups": {
+					"type": "RunAsAny"
+				},
+				"typeMeta": {
+					"apiVersion": "security.openshift.io/v1",
+					"kind": "SecurityContextConstraints"
+				},
+				"users": null,
+				"volumes": [
+					"configMap",
+					"downwardAPI",
+					"emptyDir",
+					"persistentVolumeClaim",
+					"projected",
+					"secret"
+				]
+			}
+			`,
+			response: &admissionv1beta1.AdmissionResponse{
+				UID:     "uid",
+				Allowed: false,
+				Result: &metav1.Status{
+					Status:  metav1.StatusFailure,
+					Message: "Modification of fields other than Users and Groups in the SCC is not allowed",
+					Reason:  reasonSCCProtected,
+				},
+			},
+		},
+		{
+			name: "recreate protected SCC matching the template with added group, allow",
+			scc: `{
+				"allowHostIPC": false,
+				"allowHostNetwork": false,
+				"allowHostPID": false,
+				"allowHostPorts": false,
+				"allowPrivilegeEscalation": true,
+				"allowPrivilegedContainer": false,
+				"fSGroup": {
+					"type": "RunAsAny"
+				},
+				"groups": [
+					"system:cluster-admins",
+					"myowngroup"
+				],
+				"metadata": {
+					"creationTimestamp": null,
+					"name": "anyuid",
+					"labels": {
+						"azure.openshift.io/owned-by-sync-pod": "true"
+					}
+				},
+				"priority": 10,
+				"readOnlyRootFilesystem": false,
+				"requiredDropCapabilities": [
+					"MKNOD"
+				],
+				"runAsUser": {
+					"type": "RunAsAny"
+				},
+				"seLinuxContext": {
+					"type": "MustRunAs"
+				},
+				"supplementalGroups": {
+					"type": "RunAsAny"
+				},
+				"typeMeta": {
+					"apiVersion": "security.openshift.io/v1",
+					"kind": "SecurityContextConstraints"
+				},
+				"users": null,
+				"volumes": [
+					"configMap",
+					"downwardAPI",
+					"emptyDir",
+					"persistentVolumeClaim",
+					"projected",
+					"secret"
+				]
+			}
+			`,
+			response: &admissionv1beta1.AdmissionResponse{
+				UID:     "uid",
+				Allowed: true,
+				Result: &metav1.Status{
+					Status: metav1.StatusSuccess,
+				},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := json.Marshal(&admissionv1beta1.AdmissionReview{
+				Request: &admissionv1beta1.AdmissionRequest{
+					UID:       "uid",
+					Operation: admissionv1beta1.Create,
+					Kind:      metav1.GroupVersionKind{Group: "security.openshift.io", Version: "v1", Kind: "SecurityContextConstraints"},
+					Resource:  metav1.GroupVersionResource{Group: "security.openshift.io", Version: "v1", Resource: "securitycontextconstraints"},
+					Object: runtime.RawExtension{
+						Raw: []byte(test.scc),
+					},
+				}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := &http.Request{
+				Method: http.MethodPost,
+				Header: http.Header{"Content-Type": []string{"application/json"}},
+				Body:   ioutil.NopCloser(bytes.NewReader(req)),
+			}
+
+			w := newFakeResponseWriter()
+
+			ac.handleSCC(w, r)
+
+			if w.statusCode != 200 {
+				t.Errorf("got status code %d, %s", w.statusCode, w.Buffer.String())
+			}
+
+			var rev *admissionReview
+			err = json.NewDecoder(w).Decode(&rev)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(rev.Response, withDecisionAnnotations(test.response)) {
+				t.Errorf("got respose %#v, expected %#v", rev.Response, test.response)
+			}
+		})
+	}
+}
+
 func TestHandleSCCHappyPath(t *testing.T) {
 	client := fake.NewSimpleClientset(&core.Namespace{
 		ObjectMeta: metav1.ObjectMeta{
@@ -517,10 +778,10 @@ func TestHandleSCCHappyPath(t *testing.T) {
 		},
 	})
 
-	ac := &admissionController{
-		client: client,
+	ac, err := NewAdmissionController(&config{}, client, nil)
+	if err != nil {
+		t.Fatal(err)
 	}
-	ac.protectedSCCs = ac.InitProtectedSCCs()
 
 	for _, test := range []struct {
 		name     string
@@ -663,6 +924,7 @@ func TestHandleSCCHappyPath(t *testing.T) {
 				Result: &metav1.Status{
 					Status:  metav1.StatusFailure,
 					Message: "Removal of User system:serviceaccount:kube-service-catalog:service-catalog-apiserver from SCC is not allowed",
+					Reason:  reasonSCCProtected,
 				},
 			},
 		},
@@ -807,6 +1069,7 @@ func TestHandleSCCHappyPath(t *testing.T) {
 				Result: &metav1.Status{
 					Status:  metav1.StatusFailure,
 					Message: "Removal of Group system:cluster-admins from SCC is not allowed",
+					Reason:  reasonSCCProtected,
 				},
 			},
 		},
@@ -880,6 +1143,7 @@ func TestHandleSCCHappyPath(t *testing.T) {
 				Result: &metav1.Status{
 					Status:  metav1.StatusFailure,
 					Message: "Modification of fields other than Users and Groups in the SCC is not allowed",
+					Reason:  reasonSCCProtected,
 				},
 			},
 		},
@@ -950,6 +1214,134 @@ func TestHandleSCCHappyPath(t *testing.T) {
 				Result: &metav1.Status{
 					Status:  metav1.StatusFailure,
 					Message: "Protected SCC has to have the \"azure.openshift.io/owned-by-sync-pod\" label set to true",
+					Reason:  reasonSCCProtected,
+				},
+			},
+		},
+		{
+			name: "protected SCC, benign metadata change, allow",
+			scc: `{
+				"allowHostIPC": false,
+				"allowHostNetwork": false,
+				"allowHostPID": false,
+				"allowHostPorts": false,
+				"allowPrivilegeEscalation": true,
+				"allowPrivilegedContainer": false,
+				"fSGroup": {
+					"type": "RunAsAny"
+				},
+				"groups": [
+					"system:cluster-admins"
+				],
+				"metadata": {
+					"creationTimestamp": null,
+					"name": "anyuid",
+					"resourceVersion": "4242",
+					"labels": {
+						"azure.openshift.io/owned-by-sync-pod": "true"
+					},
+					"annotations": {
+						"example.com/scratchpad": "some benign note"
+					}
+				},
+				"priority": 10,
+				"readOnlyRootFilesystem": false,
+				"requiredDropCapabilities": [
+					"MKNOD"
+				],
+				"runAsUser": {
+					"type": "RunAsAny"
+				},
+				"seLinuxContext": {
+					"type": "MustRunAs"
+				},
+				"supplementalGroups": {
+					"type": "RunAsAny"
+				},
+				"typeMeta": {
+					"apiVersion": "security.openshift.io/v1",
+					"kind": "SecurityContextConstraints"
+				},
+				"users": null,
+				"volumes": [
+					"configMap",
+					"downwardAPI",
+					"emptyDir",
+					"persistentVolumeClaim",
+					"projected",
+					"secret"
+				]
+			}
+			`,
+			response: &admissionv1beta1.AdmissionResponse{
+				UID:     "uid",
+				Allowed: true,
+				Result: &metav1.Status{
+					Status: metav1.StatusSuccess,
+				},
+			},
+		},
+		{
+			name: "protected SCC, reconcile-protect annotation disabled, forbid",
+			scc: `{
+				"allowHostIPC": false,
+				"allowHostNetwork": false,
+				"allowHostPID": false,
+				"allowHostPorts": false,
+				"allowPrivilegeEscalation": true,
+				"allowPrivilegedContainer": false,
+				"fSGroup": {
+					"type": "RunAsAny"
+				},
+				"groups": [
+					"system:cluster-admins"
+				],
+				"metadata": {
+					"creationTimestamp": null,
+					"name": "anyuid",
+					"labels": {
+						"azure.openshift.io/owned-by-sync-pod": "true"
+					},
+					"annotations": {
+						"openshift.io/reconcile-protect": "false"
+					}
+				},
+				"priority": 10,
+				"readOnlyRootFilesystem": false,
+				"requiredDropCapabilities": [
+					"MKNOD"
+				],
+				"runAsUser": {
+					"type": "RunAsAny"
+				},
+				"seLinuxContext": {
+					"type": "MustRunAs"
+				},
+				"supplementalGroups": {
+					"type": "RunAsAny"
+				},
+				"typeMeta": {
+					"apiVersion": "security.openshift.io/v1",
+					"kind": "SecurityContextConstraints"
+				},
+				"users": null,
+				"volumes": [
+					"configMap",
+					"downwardAPI",
+					"emptyDir",
+					"persistentVolumeClaim",
+					"projected",
+					"secret"
+				]
+			}
+			`,
+			response: &admissionv1beta1.AdmissionResponse{
+				UID:     "uid",
+				Allowed: false,
+				Result: &metav1.Status{
+					Status:  metav1.StatusFailure,
+					Message: "Setting the \"openshift.io/reconcile-protect\" annotation to anything but true on a protected SCC is not allowed",
+					Reason:  reasonSCCProtected,
 				},
 			},
 		},
@@ -1053,20 +1445,394 @@ func TestHandleSCCHappyPath(t *testing.T) {
 			if w.statusCode != 200 {
 				t.Errorf("got status code %d, %s", w.statusCode, w.Buffer.String())
 			}
-			if !reflect.DeepEqual(w.Header(), http.Header{"Content-Type": []string{"application/json"}}) {
+			if !reflect.DeepEqual(w.Header(), http.Header{"Content-Type": []string{"application/json"}, requestUIDHeader: []string{"uid"}}) {
 				t.Errorf("got header %#v", w.Header())
 			}
 
-			var rev *admissionv1beta1.AdmissionReview
+			var rev *admissionReview
 			err = json.NewDecoder(w).Decode(&rev)
 			if err != nil {
 				t.Fatal(err)
 			}
 
-			if !reflect.DeepEqual(rev.Response, test.response) {
+			if !reflect.DeepEqual(rev.Response, withDecisionAnnotations(test.response)) {
 				t.Errorf("got respose %#v, expected %#v", rev.Response, test.response)
 				t.Errorf("status %#v, expected %#v", rev.Response.Result, test.response.Result)
 			}
 		})
 	}
 }
+
+func TestApplyFlagPrecedence(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	registerFlags(fs)
+	err := fs.Parse([]string{"-tls-cert", "/flag/tls.crt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &config{
+		TLSCertFile: "/file/tls.crt",
+		TLSKeyFile:  "/file/tls.key",
+	}
+	c.applyFlags(fs)
+
+	//an explicitly set flag wins over the config file
+	if c.TLSCertFile != "/flag/tls.crt" {
+		t.Errorf("got TLSCertFile %s, expected the flag value to take precedence", c.TLSCertFile)
+	}
+	//a config file value wins over the flag default
+	if c.TLSKeyFile != "/file/tls.key" {
+		t.Errorf("got TLSKeyFile %s, expected the config file value to be kept", c.TLSKeyFile)
+	}
+	//with neither flag nor file value the flag default applies
+	if c.ListenAddress != defaultListenAddress {
+		t.Errorf("got ListenAddress %s, expected the default %s", c.ListenAddress, defaultListenAddress)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "adc-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	good := filepath.Join(dir, "good.yaml")
+	err = ioutil.WriteFile(good, []byte("whitelist:\n- ^whitelistedimage$\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bad := filepath.Join(dir, "bad.yaml")
+	err = ioutil.WriteFile(bad, []byte("whitelist: [unclosed\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//readable file populates the whitelist
+	var c config
+	if err := c.loadConfig(good); err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+	if !reflect.DeepEqual(c.Whitelist, []string{"^whitelistedimage$"}) {
+		t.Errorf("got whitelist %v", c.Whitelist)
+	}
+
+	//absent file is tolerated with an empty whitelist
+	var absent config
+	if err := absent.loadConfig(filepath.Join(dir, "missing.yaml")); err != nil {
+		t.Errorf("absent config file was treated as fatal: %s", err)
+	}
+	if len(absent.Whitelist) != 0 {
+		t.Errorf("got whitelist %v, expected empty", absent.Whitelist)
+	}
+
+	//a file which exists but cannot be read is fatal
+	var unreadable config
+	if err := unreadable.loadConfig(dir); err == nil {
+		t.Error("unreadable config file was not treated as fatal")
+	}
+
+	//a file which exists but cannot be parsed is fatal
+	var unparsable config
+	if err := unparsable.loadConfig(bad); err == nil {
+		t.Error("unparsable config file was not treated as fatal")
+	}
+}
+
+// TestReloadConfig exercises the SIGHUP reload path: a new whitelist becomes
+// active, and a broken config file leaves the previous policy untouched.
+func TestReloadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "aro-admission-controller.yaml")
+	err = ioutil.WriteFile(path, []byte("whitelist:\n- ^newlywhitelisted$\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ac, err := NewAdmissionController(&config{Whitelist: []string{"^oldimage$"}}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ac.reloadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	whitelisted, denylisted := ac.imagePolicies()
+	if whitelistedNow, _ := imageIsWhitelisted("newlywhitelisted", whitelisted, denylisted); !whitelistedNow {
+		t.Error("newly whitelisted image is not active after reload")
+	}
+	if whitelistedOld, _ := imageIsWhitelisted("oldimage", whitelisted, denylisted); whitelistedOld {
+		t.Error("old whitelist entry is still active after reload")
+	}
+
+	//a reload which fails must leave the current policy untouched
+	err = ioutil.WriteFile(path, []byte("whitelist:\n- (unclosed\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ac.reloadConfig(path); err == nil {
+		t.Error("reloading a broken config did not error")
+	}
+	whitelisted, denylisted = ac.imagePolicies()
+	if stillActive, _ := imageIsWhitelisted("newlywhitelisted", whitelisted, denylisted); !stillActive {
+		t.Error("previous policy was lost after a failed reload")
+	}
+}
+
+// TestValidateConfigFile verifies the lint mode accepts a good config,
+// prints its rules, and names every broken entry of a bad one.
+func TestValidateConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "validate-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	good := filepath.Join(dir, "good.yaml")
+	err = ioutil.WriteFile(good, []byte(`whitelist:
+- ^registry.example.com/.*$
+denylist:
+- ^docker.io/.*$
+serviceAccountExemptions:
+- ^system:serviceaccount:addons:.*$
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := validateConfigFile(good, &out); err != nil {
+		t.Errorf("good config was rejected: %s", err)
+	}
+	for _, rule := range []string{
+		"whitelist: ^registry.example.com/.*$",
+		"denylist: ^docker.io/.*$",
+		"serviceAccountExemption: ^system:serviceaccount:addons:.*$",
+	} {
+		if !strings.Contains(out.String(), rule) {
+			t.Errorf("rule %q was not printed: %s", rule, out.String())
+		}
+	}
+
+	bad := filepath.Join(dir, "bad.yaml")
+	err = ioutil.WriteFile(bad, []byte(`whitelist:
+- ^registry.example.com/(.*$
+denylist:
+- ^docker.io/[.*$
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = validateConfigFile(bad, &out)
+	if err == nil {
+		t.Fatal("bad config was accepted")
+	}
+	//both broken patterns have to be reported, not just the first
+	if !strings.Contains(err.Error(), "^registry.example.com/(.*$") || !strings.Contains(err.Error(), "^docker.io/[.*$") {
+		t.Errorf("not all broken patterns were reported: %s", err)
+	}
+}
+
+// TestReloadConfigPartial verifies a whitelist and profile change swaps both
+// sections without rebuilding the protected SCC map, so a pattern added
+// together with a constraining profile never runs with the blanket allow.
+func TestReloadConfigPartial(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload-partial")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "aro-admission-controller.yaml")
+	err = ioutil.WriteFile(path, []byte("whitelist:\n- ^newlywhitelisted$\nwhitelistProfiles:\n  ^newlywhitelisted$:\n    allowPrivileged: false\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ac, err := NewAdmissionController(&config{Whitelist: []string{"^oldimage$"}}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the map value identity shows whether the section was rebuilt
+	before := reflect.ValueOf(ac.protectedSCCs).Pointer()
+
+	err = ac.reloadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reflect.ValueOf(ac.protectedSCCs).Pointer() != before {
+		t.Error("whitelist-only reload rebuilt the protected SCC map")
+	}
+	whitelisted, denylisted := ac.imagePolicies()
+	if whitelistedNow, _ := imageIsWhitelisted("newlywhitelisted", whitelisted, denylisted); !whitelistedNow {
+		t.Error("new whitelist entry is not active after reload")
+	}
+	//the profile constraining the new pattern has to take effect in the
+	//same reload, otherwise the pattern runs with the blanket allow until
+	//the next restart
+	privilegedSpec := &core.PodSpec{
+		Containers: []core.Container{
+			{
+				Name:  "c",
+				Image: "newlywhitelisted",
+				SecurityContext: &core.SecurityContext{
+					Privileged: toBoolPtr(true),
+				},
+			},
+		},
+	}
+	if errs := ac.checkWhitelistProfiles(privilegedSpec, whitelisted, denylisted); len(errs) == 0 {
+		t.Error("new whitelist profile is not active after reload")
+	}
+
+	//an unchanged config leaves everything alone
+	err = ac.reloadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.ValueOf(ac.protectedSCCs).Pointer() != before {
+		t.Error("no-op reload rebuilt the protected SCC map")
+	}
+}
+
+// generateClientCA returns a throwaway CA and a client certificate signed by
+// it, for exercising the mTLS configuration without fixture files.
+func generateClientCA(t *testing.T) (caPEM []byte, client tls.Certificate) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-client-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "kube-apiserver"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientDER, err := x509.CreateCertificate(rand.Reader, clientTemplate, caCert, &clientKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = tls.Certificate{
+		Certificate: [][]byte{clientDER},
+		PrivateKey:  clientKey,
+	}
+	return caPEM, client
+}
+
+// TestClientCertificateRequired verifies that with a client CA configured
+// the admission paths only answer callers presenting a certificate signed by
+// it, while the health listener keeps answering without one.
+func TestClientCertificateRequired(t *testing.T) {
+	caPEM, clientCert := generateClientCA(t)
+	caFile, err := ioutil.TempFile("", "client-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(caFile.Name())
+	if _, err := caFile.Write(caPEM); err != nil {
+		t.Fatal(err)
+	}
+	caFile.Close()
+
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAdmissionController(&config{ClientCAFile: caFile.Name()}, nil, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tlsConfig, err := ac.serverTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Fatalf("got client auth %v, expected RequireAndVerifyClientCert", tlsConfig.ClientAuth)
+	}
+
+	srv := httptest.NewUnstartedServer(ac.Handler())
+	srv.TLS = tlsConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	serverCAs := x509.NewCertPool()
+	serverCAs.AddCert(srv.Certificate())
+	newClient := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      serverCAs,
+			Certificates: certs,
+		}}}
+	}
+
+	//a caller with a certificate signed by the client CA is served
+	resp, err := newClient(clientCert).Get(srv.URL + "/healthz")
+	if err != nil {
+		t.Fatalf("authorized client was rejected: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got status code %d for the authorized client, expected 200", resp.StatusCode)
+	}
+
+	//a caller without a certificate is rejected during the handshake
+	if resp, err := newClient().Get(srv.URL + "/healthz"); err == nil {
+		resp.Body.Close()
+		t.Error("a client without a certificate was served")
+	}
+
+	//a caller with a certificate from the wrong CA is rejected as well
+	_, wrongCert := generateClientCA(t)
+	if resp, err := newClient(wrongCert).Get(srv.URL + "/healthz"); err == nil {
+		resp.Body.Close()
+		t.Error("a client with a certificate from the wrong CA was served")
+	}
+
+	//the health listener keeps serving probes without a client certificate
+	health := httptest.NewTLSServer(ac.healthHandlers())
+	defer health.Close()
+	resp, err = health.Client().Get(health.URL + "/healthz")
+	if err != nil {
+		t.Fatalf("the health listener rejected a probe without a certificate: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got status code %d from the health listener, expected 200", resp.StatusCode)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// auditSweep runs the workloads already in the cluster through the same
+// validation admission reviews use and reports the ones which would be
+// denied, without denying anything.  Every workload controller materializes
+// as pods, so listing pods across all namespaces covers daemonsets,
+// deployments and the rest.
+func (ac *admissionController) auditSweep() {
+	pods, err := ac.client.Core().Pods("").List(metav1.ListOptions{})
+	if err != nil {
+		log.Printf("Audit sweep: error listing pods: %s", err)
+		return
+	}
+	denied := 0
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		errs, _, err := ac.validatePodAgainstSCC(context.Background(), pod, pod.Namespace)
+		if err != nil {
+			log.Printf("Audit sweep: error validating pod %s/%s: %s", pod.Namespace, pod.Name, err)
+			continue
+		}
+		if len(errs) > 0 {
+			denied++
+			log.Printf("Audit sweep: pod %s/%s would be denied: %s", pod.Namespace, pod.Name, errs.ToAggregate())
+		}
+	}
+	atomic.StoreInt64(&ac.auditDeniedWorkloads, int64(denied))
+	log.Printf("Audit sweep complete: %d of %d pods would be denied", denied, len(pods.Items))
+}
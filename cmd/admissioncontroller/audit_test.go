@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kubernetes/pkg/apis/core"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/fake"
+)
+
+// TestAuditSweep verifies a running privileged pod is reported by the sweep
+// while compliant pods are not, and that nothing is denied by it.
+func TestAuditSweep(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	client := fake.NewSimpleClientset(
+		&core.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "default",
+				Annotations: map[string]string{
+					"openshift.io/sa.scc.uid-range": "1000/10",
+					"openshift.io/sa.scc.mcs":       "mcs",
+				},
+			},
+		},
+		&core.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "privilegedpod", Namespace: "default"},
+			Spec: core.PodSpec{
+				Containers: []core.Container{
+					{
+						Name:  "c",
+						Image: "image",
+						SecurityContext: &core.SecurityContext{
+							Privileged: toBoolPtr(true),
+						},
+					},
+				},
+			},
+		},
+		&core.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "compliantpod", Namespace: "default"},
+			Spec: core.PodSpec{
+				Containers: []core.Container{
+					{Name: "c", Image: "image"},
+				},
+			},
+		},
+	)
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAdmissionController(&config{AuditSweepOnStartup: true}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ac.auditSweep()
+
+	if !strings.Contains(buf.String(), "pod default/privilegedpod would be denied") {
+		t.Errorf("privileged pod was not reported by the sweep: %s", buf.String())
+	}
+	if strings.Contains(buf.String(), "pod default/compliantpod would be denied") {
+		t.Errorf("compliant pod was wrongly reported: %s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "Audit sweep complete: 1 of 2 pods would be denied") {
+		t.Errorf("sweep summary missing or wrong: %s", buf.String())
+	}
+
+	w := newFakeResponseWriter()
+	ac.handleMetrics(w, nil)
+	if !strings.Contains(w.Buffer.String(), "aro_admission_controller_audit_denied_workloads 1") {
+		t.Errorf("audit gauge does not report the denied pod: %s", w.Buffer.String())
+	}
+}
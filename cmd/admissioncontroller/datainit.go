@@ -1,8 +1,13 @@
 package main
 
 import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/ghodss/yaml"
 	"github.com/openshift/origin/pkg/security/apis/security"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/kubernetes/pkg/apis/core"
 )
 
@@ -18,6 +23,40 @@ func toBoolPtr(b bool) *bool {
 	return &b
 }
 
+// mergeProtectedSCCTemplates loads additional protected SCC templates from the
+// given YAML or JSON files and merges them into the protected map, keyed by
+// name.  A file template with the same name as a built-in one replaces it.
+func (ac *admissionController) mergeProtectedSCCTemplates(files []string) error {
+	for _, path := range files {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		jsonData, err := yaml.YAMLToJSON(data)
+		if err != nil {
+			return fmt.Errorf("error parsing SCC template %s: %s", path, err)
+		}
+		gvk := schema.GroupVersionKind{Group: "security.openshift.io", Version: "v1", Kind: "SecurityContextConstraints"}
+		o, _, err := codec.Decode(jsonData, &gvk, nil)
+		if err != nil {
+			return fmt.Errorf("error decoding SCC template %s: %s", path, err)
+		}
+		scc, ok := o.(*security.SecurityContextConstraints)
+		if !ok {
+			return fmt.Errorf("file %s does not contain a SecurityContextConstraints", path)
+		}
+		if scc.Name == "" {
+			return fmt.Errorf("SCC template in %s has no name", path)
+		}
+		name := scc.Name
+		//the templates carry no ObjectMeta, the protection-relevant metadata
+		//of incoming SCCs is checked separately in verifySCC
+		scc.ObjectMeta = metav1.ObjectMeta{}
+		ac.protectedSCCs[name] = *scc
+	}
+	return nil
+}
+
 func (ac *admissionController) InitProtectedSCCs() map[string]security.SecurityContextConstraints {
 	result := map[string]security.SecurityContextConstraints{
 		"anyuid": {
@@ -287,3 +326,27 @@ func (ac *admissionController) InitProtectedSCCs() map[string]security.SecurityC
 	}
 	return result
 }
+
+// loadRestrictedSCC reads the baseline SCC pods are validated against from a
+// YAML or JSON file, for clusters whose restricted SCC diverges from the
+// bootstrap policy.
+func loadRestrictedSCC(path string) (*security.SecurityContextConstraints, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing restricted SCC %s: %s", path, err)
+	}
+	gvk := schema.GroupVersionKind{Group: "security.openshift.io", Version: "v1", Kind: "SecurityContextConstraints"}
+	o, _, err := codec.Decode(jsonData, &gvk, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding restricted SCC %s: %s", path, err)
+	}
+	scc, ok := o.(*security.SecurityContextConstraints)
+	if !ok {
+		return nil, fmt.Errorf("file %s does not contain a SecurityContextConstraints", path)
+	}
+	return scc, nil
+}
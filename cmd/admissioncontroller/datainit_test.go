@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kubernetes/pkg/apis/core"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/fake"
+)
+
+func TestMergeProtectedSCCTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "protected-sccs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "custom-protected.yaml")
+	err = ioutil.WriteFile(path, []byte(`apiVersion: security.openshift.io/v1
+kind: SecurityContextConstraints
+metadata:
+  name: custom-protected
+allowPrivilegedContainer: false
+allowHostNetwork: false
+allowHostPorts: false
+allowHostPID: false
+allowHostIPC: false
+allowPrivilegeEscalation: true
+requiredDropCapabilities:
+- MKNOD
+seLinuxContext:
+  type: MustRunAs
+runAsUser:
+  type: RunAsAny
+supplementalGroups:
+  type: RunAsAny
+fsGroup:
+  type: RunAsAny
+groups:
+- system:cluster-admins
+volumes:
+- configMap
+- secret
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ac := &admissionController{}
+	ac.protectedSCCs = ac.InitProtectedSCCs()
+
+	err = ac.mergeProtectedSCCTemplates([]string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template, found := ac.protectedSCCs["custom-protected"]
+	if !found {
+		t.Fatal("custom-protected template was not merged")
+	}
+	//the built-in templates have to still be present
+	if _, found := ac.protectedSCCs["privileged"]; !found {
+		t.Fatal("built-in privileged template went missing")
+	}
+
+	//a modification of the custom protected SCC has to be rejected
+	modified := template.DeepCopy()
+	modified.Labels = map[string]string{"azure.openshift.io/owned-by-sync-pod": "true"}
+	modified.AllowHostPID = true
+	if errs := verifySCC(*modified, template); errs == nil {
+		t.Error("modification of a custom protected SCC was not rejected")
+	}
+
+	//additional users on the custom protected SCC remain allowed
+	extended := template.DeepCopy()
+	extended.Labels = map[string]string{"azure.openshift.io/owned-by-sync-pod": "true"}
+	extended.Users = append(extended.Users, "myuser")
+	if errs := verifySCC(*extended, template); errs != nil {
+		t.Errorf("adding a user to a custom protected SCC was rejected: %s", errs)
+	}
+}
+
+func TestMergeProtectedSCCTemplatesErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "protected-sccs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	noName := filepath.Join(dir, "no-name.yaml")
+	err = ioutil.WriteFile(noName, []byte(`apiVersion: security.openshift.io/v1
+kind: SecurityContextConstraints
+seLinuxContext:
+  type: MustRunAs
+runAsUser:
+  type: RunAsAny
+supplementalGroups:
+  type: RunAsAny
+fsGroup:
+  type: RunAsAny
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ac := &admissionController{}
+	ac.protectedSCCs = ac.InitProtectedSCCs()
+
+	if err := ac.mergeProtectedSCCTemplates([]string{filepath.Join(dir, "missing.yaml")}); err == nil {
+		t.Error("missing template file was not reported")
+	}
+	if err := ac.mergeProtectedSCCTemplates([]string{noName}); err == nil {
+		t.Error("nameless template was not reported")
+	}
+}
+
+// TestLoadRestrictedSCC verifies pods are validated against a baseline SCC
+// loaded from file rather than the bootstrap restricted SCC.
+func TestLoadRestrictedSCC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restricted-scc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "restricted.yaml")
+	err = ioutil.WriteFile(path, []byte(`apiVersion: security.openshift.io/v1
+kind: SecurityContextConstraints
+metadata:
+  name: custom-restricted
+allowPrivilegedContainer: true
+allowPrivilegeEscalation: true
+seLinuxContext:
+  type: RunAsAny
+runAsUser:
+  type: RunAsAny
+supplementalGroups:
+  type: RunAsAny
+fsGroup:
+  type: RunAsAny
+volumes:
+- configMap
+- secret
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restricted, err := loadRestrictedSCC(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restricted.Name != "custom-restricted" {
+		t.Fatalf("got SCC %q", restricted.Name)
+	}
+
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	ac, err := NewAdmissionController(&config{}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//privileged pods pass against the custom baseline, unlike against the
+	//bootstrap restricted SCC
+	pod := &core.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+		Spec: core.PodSpec{
+			Containers: []core.Container{
+				{
+					Name:  "c",
+					Image: "image",
+					SecurityContext: &core.SecurityContext{
+						Privileged: toBoolPtr(true),
+					},
+				},
+			},
+		},
+	}
+	errs, _, err := ac.validatePodAgainstSCC(context.Background(), pod, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 0 {
+		t.Errorf("privileged pod was rejected against the custom baseline: %v", errs)
+	}
+}
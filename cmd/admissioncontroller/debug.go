@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+)
+
+// handleDebugConfig reports the policy currently loaded, so "why was my pod
+// denied" can be answered without grepping logs.  It is restricted to
+// loopback callers and contains no secrets: only the compiled patterns and
+// the protected SCC names.
+func (ac *admissionController) handleDebugConfig(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsLoopback() {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	effective := struct {
+		Whitelist                []string `json:"whitelist"`
+		Denylist                 []string `json:"denylist"`
+		ServiceAccountExemptions []string `json:"serviceAccountExemptions"`
+		ProtectedSCCs            []string `json:"protectedSCCs"`
+	}{}
+	ac.policyLock.RLock()
+	for _, rx := range ac.whitelistedImages {
+		effective.Whitelist = append(effective.Whitelist, rx.String())
+	}
+	for _, rx := range ac.denylistedImages {
+		effective.Denylist = append(effective.Denylist, rx.String())
+	}
+	for _, rx := range ac.exemptedUsers {
+		effective.ServiceAccountExemptions = append(effective.ServiceAccountExemptions, rx.String())
+	}
+	for name := range ac.protectedSCCs {
+		effective.ProtectedSCCs = append(effective.ProtectedSCCs, name)
+	}
+	ac.policyLock.RUnlock()
+	sort.Strings(effective.ProtectedSCCs)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(effective)
+	if err != nil {
+		log.Printf("Error encoding debug config: %s", err)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// TestHandleDebugConfig verifies the endpoint reflects the loaded policy for
+// loopback callers and refuses everyone else.
+func TestHandleDebugConfig(t *testing.T) {
+	ac, err := NewAdmissionController(&config{
+		Whitelist:                []string{"^registry.example.com/.*$"},
+		Denylist:                 []string{"^docker.io/.*$"},
+		ServiceAccountExemptions: []string{"^system:serviceaccount:addons:.*$"},
+	}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := newFakeResponseWriter()
+	ac.handleDebugConfig(w, &http.Request{RemoteAddr: "10.0.0.1:39234"})
+	if w.statusCode != http.StatusForbidden {
+		t.Errorf("got status code %d for a non-loopback caller, expected 403", w.statusCode)
+	}
+
+	w = newFakeResponseWriter()
+	ac.handleDebugConfig(w, &http.Request{RemoteAddr: "127.0.0.1:39234"})
+	if w.statusCode != 200 {
+		t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+	}
+
+	var effective struct {
+		Whitelist                []string `json:"whitelist"`
+		Denylist                 []string `json:"denylist"`
+		ServiceAccountExemptions []string `json:"serviceAccountExemptions"`
+		ProtectedSCCs            []string `json:"protectedSCCs"`
+	}
+	err = json.NewDecoder(w).Decode(&effective)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(effective.Whitelist, []string{"^registry.example.com/.*$"}) {
+		t.Errorf("got whitelist %v", effective.Whitelist)
+	}
+	if !reflect.DeepEqual(effective.Denylist, []string{"^docker.io/.*$"}) {
+		t.Errorf("got denylist %v", effective.Denylist)
+	}
+	if !reflect.DeepEqual(effective.ServiceAccountExemptions, []string{"^system:serviceaccount:addons:.*$"}) {
+		t.Errorf("got exemptions %v", effective.ServiceAccountExemptions)
+	}
+	found := false
+	for _, name := range effective.ProtectedSCCs {
+		if name == "privileged" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("protected SCC names do not include privileged: %v", effective.ProtectedSCCs)
+	}
+}
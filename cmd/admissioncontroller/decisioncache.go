@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/kubernetes/pkg/apis/core"
+)
+
+//The API server retries webhook calls on timeout and controllers re-submit
+//identical specs; for those the prior decision is served from a short-TTL
+//cache instead of re-running the SCC evaluation and its namespace lookup.
+
+type decisionCacheEntry struct {
+	errs            field.ErrorList
+	matchedPatterns []string
+	warnings        []string
+	expires         time.Time
+}
+
+// decisionCacheKey derives the cache key from the decoded pod spec and the
+// namespace it is reviewed in.  An empty key disables caching for the request.
+func decisionCacheKey(spec *core.PodSpec, namespace string) string {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(append(data, []byte("\x00"+namespace)...))
+	return string(sum[:])
+}
+
+// cachedDecision returns the stored decision for the key if it has not
+// expired
+func (ac *admissionController) cachedDecision(key string) (field.ErrorList, []string, []string, bool) {
+	if ac.decisionCache == nil || key == "" {
+		return nil, nil, nil, false
+	}
+	ac.decisionCacheLock.Lock()
+	defer ac.decisionCacheLock.Unlock()
+	entry, found := ac.decisionCache[key]
+	if !found || time.Now().After(entry.expires) {
+		delete(ac.decisionCache, key)
+		return nil, nil, nil, false
+	}
+	return entry.errs, entry.matchedPatterns, entry.warnings, true
+}
+
+// storeDecision records the decision for the key until the TTL expires
+func (ac *admissionController) storeDecision(key string, errs field.ErrorList, matchedPatterns, warnings []string) {
+	if ac.decisionCache == nil || key == "" {
+		return
+	}
+	ac.decisionCacheLock.Lock()
+	defer ac.decisionCacheLock.Unlock()
+	ac.decisionCache[key] = decisionCacheEntry{
+		errs:            errs,
+		matchedPatterns: matchedPatterns,
+		warnings:        warnings,
+		expires:         time.Now().Add(time.Duration(ac.decisionCacheTTLSeconds) * time.Second),
+	}
+}
+
+// purgeDecisionCache drops all cached decisions, e.g. after a config reload
+// changed the policy they were computed under
+func (ac *admissionController) purgeDecisionCache() {
+	if ac.decisionCache == nil {
+		return
+	}
+	ac.decisionCacheLock.Lock()
+	defer ac.decisionCacheLock.Unlock()
+	ac.decisionCache = map[string]decisionCacheEntry{}
+}
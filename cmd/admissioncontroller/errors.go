@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// The admission flow distinguishes three failure categories: policy
+// violations are answered as well-formed denials, decode failures are
+// answered according to the lenientDecode setting, and internal errors fail
+// the request with an HTTP 500 so the API server retries instead of
+// recording a denial.  Wrapping an error types it; untyped errors default to
+// policy violations, which is what the existing validation paths aggregate.
+
+const (
+	categoryPolicyViolation = "policy_violation"
+	categoryDecode          = "decode"
+	categoryInternal        = "internal"
+)
+
+type admissionError struct {
+	category string
+	cause    error
+}
+
+func (e *admissionError) Error() string { return e.cause.Error() }
+
+// ErrPolicyViolation marks err as a policy violation, answered as a
+// well-formed denial.
+func ErrPolicyViolation(err error) error {
+	return &admissionError{category: categoryPolicyViolation, cause: err}
+}
+
+// ErrDecode marks err as a decode failure, answered as an HTTP 400 in strict
+// mode or a denial in lenient mode.
+func ErrDecode(err error) error {
+	return &admissionError{category: categoryDecode, cause: err}
+}
+
+// ErrInternal marks err as an internal error, answered as an HTTP 500.
+func ErrInternal(err error) error {
+	return &admissionError{category: categoryInternal, cause: err}
+}
+
+// errorCategory returns the category of err, defaulting to policy violation
+// for untyped errors.
+func errorCategory(err error) string {
+	if ae, ok := err.(*admissionError); ok {
+		return ae.category
+	}
+	return categoryPolicyViolation
+}
+
+// aggregateCategory returns the dominant category of the aggregate: internal
+// outranks decode outranks policy violation, mirroring how the outcomes
+// escalate.
+func aggregateCategory(errs errors.Aggregate) string {
+	category := categoryPolicyViolation
+	for _, e := range errs.Errors() {
+		switch errorCategory(e) {
+		case categoryInternal:
+			return categoryInternal
+		case categoryDecode:
+			category = categoryDecode
+		}
+	}
+	return category
+}
+
+// Denials additionally carry a stable reason code in Result.Reason, so
+// tooling can branch on the class of denial without parsing the
+// human-readable Result.Message.  These values are part of the controller's
+// external contract; do not rename them.
+const (
+	reasonPolicyViolation          metav1.StatusReason = "PolicyViolation"
+	reasonPrivilegedNotWhitelisted metav1.StatusReason = "PrivilegedNotWhitelisted"
+	reasonRegistryNotAllowed       metav1.StatusReason = "RegistryNotAllowed"
+	reasonSCCProtected             metav1.StatusReason = "SCCProtected"
+)
+
+type reasonedError struct {
+	reason metav1.StatusReason
+	cause  error
+}
+
+func (e *reasonedError) Error() string { return e.cause.Error() }
+
+// withReason tags err with an explicit denial reason code, for denial sites
+// whose class is not recoverable from the violation's field path.
+func withReason(reason metav1.StatusReason, err error) error {
+	return &reasonedError{reason: reason, cause: err}
+}
+
+// denialReason returns the reason code for a denial aggregate.  An explicit
+// tag wins; otherwise the violations are classified by their field paths:
+// privileged-container denials outrank image denials, and only violations on
+// an image field itself (unqualified or unverifiable images) count as image
+// denials — the contextual "images which are not whitelisted" note
+// accompanies every SCC denial of a non-whitelisted pod and says nothing
+// about the image policy.  Anything not recognized is reported as a generic
+// policy violation.
+func denialReason(errs errors.Aggregate) metav1.StatusReason {
+	for _, e := range errs.Errors() {
+		if re, ok := e.(*reasonedError); ok {
+			return re.reason
+		}
+	}
+	reason := reasonPolicyViolation
+	for _, e := range errs.Errors() {
+		ferr, ok := e.(*field.Error)
+		if !ok {
+			continue
+		}
+		if strings.Contains(ferr.Field, "securityContext.privileged") {
+			return reasonPrivilegedNotWhitelisted
+		}
+		if strings.HasSuffix(ferr.Field, ".image") {
+			reason = reasonRegistryNotAllowed
+		}
+	}
+	return reason
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/util/errors"
+)
+
+// TestErrorCategorization verifies typed errors carry their category and
+// untyped errors default to policy violations.
+func TestErrorCategorization(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		err      error
+		category string
+	}{
+		{name: "policy violation", err: ErrPolicyViolation(fmt.Errorf("denied")), category: categoryPolicyViolation},
+		{name: "decode", err: ErrDecode(fmt.Errorf("bad json")), category: categoryDecode},
+		{name: "internal", err: ErrInternal(fmt.Errorf("api timeout")), category: categoryInternal},
+		{name: "untyped defaults to policy violation", err: fmt.Errorf("denied"), category: categoryPolicyViolation},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := errorCategory(test.err); got != test.category {
+				t.Errorf("got category %q, expected %q", got, test.category)
+			}
+		})
+	}
+
+	//internal outranks decode outranks policy violation in an aggregate
+	agg := errors.NewAggregate([]error{fmt.Errorf("denied"), ErrDecode(fmt.Errorf("bad json")), ErrInternal(fmt.Errorf("api timeout"))})
+	if got := aggregateCategory(agg); got != categoryInternal {
+		t.Errorf("got aggregate category %q, expected %q", got, categoryInternal)
+	}
+	agg = errors.NewAggregate([]error{fmt.Errorf("denied"), ErrDecode(fmt.Errorf("bad json"))})
+	if got := aggregateCategory(agg); got != categoryDecode {
+		t.Errorf("got aggregate category %q, expected %q", got, categoryDecode)
+	}
+}
+
+// TestSendResultByCategory verifies sendResult maps each error category to
+// its outcome: denial, 400/denial depending on decode strictness, or 500.
+func TestSendResultByCategory(t *testing.T) {
+	for _, test := range []struct {
+		name           string
+		lenient        bool
+		err            error
+		expectedStatus int
+	}{
+		{name: "policy violation is a denial", err: ErrPolicyViolation(fmt.Errorf("denied")), expectedStatus: 200},
+		{name: "untyped error is a denial", err: fmt.Errorf("denied"), expectedStatus: 200},
+		{name: "decode error in strict mode is a 400", err: ErrDecode(fmt.Errorf("bad json")), expectedStatus: 400},
+		{name: "decode error in lenient mode is a denial", lenient: true, err: ErrDecode(fmt.Errorf("bad json")), expectedStatus: 200},
+		{name: "internal error is a 500", err: ErrInternal(fmt.Errorf("api timeout")), expectedStatus: 500},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ac, err := NewAdmissionController(&config{LenientDecode: test.lenient}, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := newFakeResponseWriter()
+			ac.sendResult(errors.NewAggregate([]error{test.err}), w, &admissionv1beta1.AdmissionRequest{UID: "uid"}, nil)
+			if w.statusCode != test.expectedStatus {
+				t.Errorf("got status code %d, expected %d: %s", w.statusCode, test.expectedStatus, w.Buffer.String())
+			}
+		})
+	}
+}
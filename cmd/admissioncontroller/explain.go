@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/kubernetes/pkg/apis/core"
+)
+
+// explanation is the decision report returned by /explain for a replayed
+// pod: whether it would be admitted, which whitelist patterns matched, and
+// the specific violations otherwise.
+type explanation struct {
+	Allowed         bool                   `json:"allowed"`
+	Whitelisted     bool                   `json:"whitelisted"`
+	MatchedPatterns []string               `json:"matchedPatterns,omitempty"`
+	Violations      []explanationViolation `json:"violations,omitempty"`
+}
+
+type explanationViolation struct {
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// handleExplain replays a raw Pod (not wrapped in an AdmissionReview)
+// through the same validation the webhook applies and returns the full
+// decision, so support engineers can debug denials without crafting
+// AdmissionReview payloads.  Like /debug/config it is restricted to loopback
+// callers.  The namespace is taken from the pod, or from the namespace query
+// parameter when the manifest omits it.
+func (ac *admissionController) handleExplain(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsLoopback() {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, ac.maxRequestBytes()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	gvk := schema.GroupVersionKind{Version: "v1", Kind: "Pod"}
+	o, _, err := codec.Decode(body, &gvk, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pod, ok := o.(*core.Pod)
+	if !ok {
+		http.Error(w, "the object under review is not a Pod", http.StatusBadRequest)
+		return
+	}
+	namespace := pod.Namespace
+	if namespace == "" {
+		namespace = r.URL.Query().Get("namespace")
+	}
+
+	whitelistedImages, denylistedImages := ac.imagePolicies()
+	whitelisted, _, _ := podSpecIsWhitelisted(&pod.Spec, whitelistedImages, denylistedImages)
+	errs, matchedPatterns, err := ac.validatePodAgainstSCC(context.Background(), pod, namespace)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result := explanation{
+		Allowed:         len(errs) == 0,
+		Whitelisted:     whitelisted,
+		MatchedPatterns: matchedPatterns,
+	}
+	for _, ferr := range errs {
+		result.Violations = append(result.Violations, explanationViolation{
+			Field:   ferr.Field,
+			Message: ferr.ErrorBody(),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		log.Printf("Error encoding explanation: %s", err)
+	}
+}
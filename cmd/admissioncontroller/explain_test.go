@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kubernetes/pkg/apis/core"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/fake"
+)
+
+// TestHandleExplain verifies /explain replays a raw pod through validation
+// and reports the violations, and that non-loopback callers are rejected.
+func TestHandleExplain(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAdmissionController(&config{
+		Whitelist: []string{"^whitelistedimage$"},
+	}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod, err := json.Marshal(&corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name:            "c",
+					Image:           "regularimage",
+					SecurityContext: &corev1.SecurityContext{Privileged: toBoolPtr(true)},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &http.Request{
+		Method:     http.MethodPost,
+		RemoteAddr: "10.0.0.1:1234",
+		Body:       ioutil.NopCloser(bytes.NewReader(pod)),
+	}
+	w := newFakeResponseWriter()
+	ac.handleExplain(w, r)
+	if w.statusCode != http.StatusForbidden {
+		t.Errorf("got status code %d for a non-loopback caller, expected 403", w.statusCode)
+	}
+
+	r = &http.Request{
+		Method:     http.MethodPost,
+		RemoteAddr: "127.0.0.1:1234",
+		Body:       ioutil.NopCloser(bytes.NewReader(pod)),
+	}
+	w = newFakeResponseWriter()
+	ac.handleExplain(w, r)
+	if w.statusCode != 200 {
+		t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+	}
+
+	var result explanation
+	err = json.NewDecoder(w).Decode(&result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed || result.Whitelisted {
+		t.Errorf("expected a denied, non-whitelisted explanation, got %+v", result)
+	}
+	found := false
+	for _, v := range result.Violations {
+		if v.Field == "spec.containers[0].securityContext.privileged" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected a privileged violation, got %+v", result.Violations)
+	}
+
+	//a whitelisted pod is explained with its matched pattern
+	pod, err = json.Marshal(&corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name:            "c",
+					Image:           "whitelistedimage",
+					SecurityContext: &corev1.SecurityContext{Privileged: toBoolPtr(true)},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = &http.Request{
+		Method:     http.MethodPost,
+		RemoteAddr: "127.0.0.1:1234",
+		Body:       ioutil.NopCloser(bytes.NewReader(pod)),
+	}
+	w = newFakeResponseWriter()
+	ac.handleExplain(w, r)
+	if w.statusCode != 200 {
+		t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+	}
+	err = json.NewDecoder(w).Decode(&result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Allowed || !result.Whitelisted || len(result.MatchedPatterns) == 0 {
+		t.Errorf("expected an allowed, whitelisted explanation with its pattern, got %+v", result)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/kubernetes/pkg/apis/core"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/fake"
+)
+
+// fuzzSeeds returns a corpus derived from the payloads used by the handler
+// tests: a well-formed pod review, near-valid reviews with required fields
+// missing or wrong, a decodable kind without an unpacker, and plain garbage.
+func fuzzSeeds(f *testing.F) [][]byte {
+	pod, err := json.Marshal(&corev1.Pod{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Pod",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Image: "regularimage",
+				},
+			},
+		},
+	})
+	if err != nil {
+		f.Fatal(err)
+	}
+	review, err := json.Marshal(&admissionv1beta1.AdmissionReview{
+		Request: &admissionv1beta1.AdmissionRequest{
+			UID:      "uid",
+			Kind:     metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Resource: metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+			Object:   runtime.RawExtension{Raw: pod},
+		},
+	})
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	return [][]byte{
+		review,
+		review[:len(review)/2],
+		[]byte("this is not JSON"),
+		[]byte("{}"),
+		[]byte("null"),
+		[]byte(`{"request": null}`),
+		[]byte(`{"request": {"uid": "uid", "kind": {"version": "v1", "kind": "Pod"}, "resource": {"version": "v1", "resource": "pods"}}}`),
+		//a Service decodes cleanly but has no unpacker
+		[]byte(`{"request": {"uid": "uid", "kind": {"version": "v1", "kind": "Service"}, "resource": {"version": "v1", "resource": "services"}, "object": {"kind": "Service", "apiVersion": "v1", "metadata": {"namespace": "default"}}}}`),
+		//the object's raw bytes disagree with the kind under review
+		[]byte(`{"request": {"uid": "uid", "kind": {"version": "v1", "kind": "Pod"}, "resource": {"version": "v1", "resource": "pods"}, "object": {"kind": "Pod", "apiVersion": "v1", "spec": {"containers": 42}}}}`),
+		[]byte(`{"request": {"uid": "uid", "kind": {"group": "security.openshift.io", "version": "v1", "kind": "SecurityContextConstraints"}, "resource": {"group": "security.openshift.io", "version": "v1", "resource": "securitycontextconstraints"}, "name": "privileged", "operation": "UPDATE", "object": {"kind": "SecurityContextConstraints", "apiVersion": "security.openshift.io/v1", "metadata": {"name": "privileged"}}}}`),
+	}
+}
+
+func newFuzzAdmissionController(f *testing.F) *admissionController {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	ac, err := NewAdmissionController(&config{}, client, restricted)
+	if err != nil {
+		f.Fatal(err)
+	}
+	return ac
+}
+
+func fuzzRequest(body []byte) *http.Request {
+	return &http.Request{
+		Method: http.MethodPost,
+		Header: http.Header{"Content-Type": []string{"application/json"}},
+		Body:   ioutil.NopCloser(bytes.NewReader(body)),
+	}
+}
+
+// fuzzHandler drives a review handler with arbitrary bodies and checks that
+// it neither panics nor produces a malformed response: the status code must
+// be one the handlers are specified to return, and a 200 must carry an
+// AdmissionReview with a response.
+func fuzzHandler(f *testing.F, handle func(*admissionController, http.ResponseWriter, *http.Request)) {
+	for _, seed := range fuzzSeeds(f) {
+		f.Add(seed)
+	}
+	ac := newFuzzAdmissionController(f)
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		w := newFakeResponseWriter()
+		handle(ac, w, fuzzRequest(body))
+		switch w.statusCode {
+		case http.StatusOK, http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusInternalServerError, http.StatusNotImplemented:
+		default:
+			t.Errorf("unexpected status code %d", w.statusCode)
+		}
+		if w.statusCode == http.StatusOK {
+			var review admissionv1beta1.AdmissionReview
+			if err := json.Unmarshal(w.Bytes(), &review); err != nil {
+				t.Errorf("200 response is not an AdmissionReview: %s", err)
+			} else if review.Response == nil {
+				t.Errorf("200 response carries no response: %s", w.Bytes())
+			}
+		}
+	})
+}
+
+func FuzzHandleWhitelist(f *testing.F) {
+	fuzzHandler(f, (*admissionController).handleWhitelist)
+}
+
+func FuzzHandleSCC(f *testing.F) {
+	fuzzHandler(f, (*admissionController).handleSCC)
+}
+
+func FuzzGetAdmissionReviewRequest(f *testing.F) {
+	for _, seed := range fuzzSeeds(f) {
+		f.Add(seed)
+	}
+	ac := newFuzzAdmissionController(f)
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		w := newFakeResponseWriter()
+		req, errcode := ac.getAdmissionReviewRequest(w, fuzzRequest(body))
+		if (req == nil) == (errcode == 0) {
+			t.Errorf("exactly one of a request and an error code expected, got %v, %d", req, errcode)
+		}
+	})
+}
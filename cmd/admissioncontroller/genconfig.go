@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/ghodss/yaml"
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/kubernetes/pkg/api/legacyscheme"
+)
+
+// genWebhookConfig prints the ValidatingWebhookConfiguration and the
+// ClusterRoleBinding the setup goroutine would register, as YAML documents,
+// so they can instead be applied declaratively (e.g. via GitOps).  The CA
+// bundle is read from the default location when present; otherwise the
+// caBundle field is left empty for the deployment pipeline to inject.
+func (ac *admissionController) genWebhookConfig(caBundle []byte, out io.Writer) error {
+	outputCodec := legacyscheme.Codecs.LegacyCodec(admissionregistrationv1beta1.SchemeGroupVersion, rbacv1.SchemeGroupVersion)
+	for _, o := range []runtime.Object{
+		ac.initializeValidatingWebhookConfiguration(caBundle),
+		ac.initializeClusterRoleBinding(),
+	} {
+		b, err := runtime.Encode(outputCodec, o)
+		if err != nil {
+			return err
+		}
+		b, err = yaml.JSONToYAML(b)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(out, "---\n%s", b)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// genWebhookConfigFile loads the configuration (for the webhook service
+// overrides) and prints the generated manifests to out.
+func genWebhookConfigFile(configPath string, out io.Writer) error {
+	var c config
+	err := c.loadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	ac, err := NewAdmissionController(&c, nil, nil)
+	if err != nil {
+		return err
+	}
+	//without a CA bundle on disk the field stays empty for later injection
+	caBundle, _ := ioutil.ReadFile("/etc/aro-admission-controller/ca.crt")
+	return ac.genWebhookConfig(caBundle, out)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/kubernetes/pkg/apis/admissionregistration"
+	"k8s.io/kubernetes/pkg/apis/rbac"
+)
+
+// TestGenWebhookConfig verifies the generated YAML round-trips to the same
+// objects the setup goroutine would register.
+func TestGenWebhookConfig(t *testing.T) {
+	ac := &admissionController{}
+
+	var buf bytes.Buffer
+	err := ac.genWebhookConfig([]byte("cabundle"), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var objects []interface{}
+	for _, doc := range strings.Split(buf.String(), "---\n") {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+		j, err := yaml.YAMLToJSON([]byte(doc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		o, _, err := codec.Decode(j, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		objects = append(objects, o)
+	}
+	if len(objects) != 2 {
+		t.Fatalf("got %d objects, expected 2", len(objects))
+	}
+
+	vwc, ok := objects[0].(*admissionregistration.ValidatingWebhookConfiguration)
+	if !ok {
+		t.Fatalf("first object is %T, expected a ValidatingWebhookConfiguration", objects[0])
+	}
+	expected := ac.initializeValidatingWebhookConfiguration([]byte("cabundle"))
+	if vwc.Name != expected.Name || !reflect.DeepEqual(vwc.Webhooks, expected.Webhooks) {
+		t.Errorf("generated ValidatingWebhookConfiguration does not round-trip")
+	}
+
+	crb, ok := objects[1].(*rbac.ClusterRoleBinding)
+	if !ok {
+		t.Fatalf("second object is %T, expected a ClusterRoleBinding", objects[1])
+	}
+	expectedCRB := ac.initializeClusterRoleBinding()
+	if crb.Name != expectedCRB.Name || !reflect.DeepEqual(crb.Subjects, expectedCRB.Subjects) || crb.RoleRef != expectedCRB.RoleRef {
+		t.Errorf("generated ClusterRoleBinding does not round-trip")
+	}
+}
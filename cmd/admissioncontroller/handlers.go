@@ -1,22 +1,30 @@
 package main
 
 import (
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"reflect"
 	"regexp"
 	"strings"
 
+	"github.com/ghodss/yaml"
+
 	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 	"k8s.io/kubernetes/pkg/apis/apps"
+	authorizationapi "k8s.io/kubernetes/pkg/apis/authorization"
 	"k8s.io/kubernetes/pkg/apis/batch"
 	"k8s.io/kubernetes/pkg/apis/core"
 	"k8s.io/kubernetes/pkg/apis/extensions"
@@ -30,21 +38,35 @@ import (
 	_ "github.com/openshift/origin/pkg/security/apis/security/install"
 )
 
+// requestUIDHeader carries the AdmissionRequest UID on every response, for
+// correlating webhook responses with API server audit entries
+const requestUIDHeader = "X-Aro-Admission-Request-Uid"
+
 // verifySCC makes sure that nothing besides additional users or groups are
 // different between the SCC and an SCCTemplate.
 func verifySCC(scc security.SecurityContextConstraints, sccTemplate security.SecurityContextConstraints) errors.Aggregate {
 	var errs []error
-	//checking ObjectMeta
+	//checking ObjectMeta: only metadata relevant for protection is verified,
+	//benign fields (resourceVersion, uid, timestamps, extra annotations) are
+	//free to differ
 	labels := scc.ObjectMeta.GetLabels()
 	if labels["azure.openshift.io/owned-by-sync-pod"] != "true" {
 		errs = append(errs, fmt.Errorf("Protected SCC has to have the \"azure.openshift.io/owned-by-sync-pod\" label set to true"))
 	}
+	annotations := scc.ObjectMeta.GetAnnotations()
+	if v, found := annotations["openshift.io/reconcile-protect"]; found && v != "true" {
+		errs = append(errs, fmt.Errorf("Setting the \"openshift.io/reconcile-protect\" annotation to anything but true on a protected SCC is not allowed"))
+	}
 
+	//user and group names are case-sensitive per the API, but surrounding
+	//whitespace is not significant there: a whitespace-padded variant of a
+	//protected entry would effectively remove the real one while passing a
+	//literal comparison, so names are trimmed before comparing
 	//Allow only if the new Groups are a superset of the template Groups
 	for _, templateGroup := range sccTemplate.Groups {
 		found := false
 		for _, sccGroup := range scc.Groups {
-			if templateGroup == sccGroup {
+			if strings.TrimSpace(templateGroup) == strings.TrimSpace(sccGroup) {
 				found = true
 				break
 			}
@@ -58,7 +80,7 @@ func verifySCC(scc security.SecurityContextConstraints, sccTemplate security.Sec
 	for _, templateUser := range sccTemplate.Users {
 		found := false
 		for _, sccUser := range scc.Users {
-			if templateUser == sccUser {
+			if strings.TrimSpace(templateUser) == strings.TrimSpace(sccUser) {
 				found = true
 				break
 			}
@@ -74,226 +96,1423 @@ func verifySCC(scc security.SecurityContextConstraints, sccTemplate security.Sec
 	localSccTemplate.Groups = []string{}
 	//added only to remove function side effects
 	localScc := scc.DeepCopy()
+	//older clusters express the allowed seccomp profiles via an annotation
+	//instead of the seccompProfiles field; normalize to the field form before
+	//comparing so the two representations are treated as equal
+	if len(localScc.SeccompProfiles) == 0 {
+		if profiles, found := scc.ObjectMeta.GetAnnotations()["seccomp.security.alpha.kubernetes.io/allowedProfileNames"]; found && profiles != "" {
+			localScc.SeccompProfiles = strings.Split(profiles, ",")
+		}
+	}
+	//a Priority change reorders which SCC is selected cluster-wide and can
+	//weaken the security posture without touching any privilege field, so it
+	//is called out explicitly instead of hiding in the generic message below
+	if !reflect.DeepEqual(scc.Priority, sccTemplate.Priority) {
+		errs = append(errs, fmt.Errorf("Changing the Priority of a protected SCC is not allowed"))
+		localScc.Priority = localSccTemplate.Priority
+	}
 	//ignore ObjectMeta in further comparisons
 	localScc.ObjectMeta = metav1.ObjectMeta{}
 	localScc.Users = []string{}
 	localScc.Groups = []string{}
+	//capability names are case-insensitive in practice: mknod and MKNOD mean
+	//the same capability and must not trigger a modification denial
+	normalizeCapabilities(localScc)
+	normalizeCapabilities(localSccTemplate)
 	if !reflect.DeepEqual(localScc, localSccTemplate) {
 		errs = append(errs, fmt.Errorf("Modification of fields other than Users and Groups in the SCC is not allowed"))
 	}
 	return errors.NewAggregate(errs)
 }
 
+// normalizeCapabilities upper-cases the capability names of an SCC in place,
+// so differently-cased but semantically equal sets compare as equal.
+func normalizeCapabilities(scc *security.SecurityContextConstraints) {
+	for _, list := range [][]core.Capability{scc.DefaultAddCapabilities, scc.RequiredDropCapabilities, scc.AllowedCapabilities} {
+		for i := range list {
+			list[i] = core.Capability(strings.ToUpper(string(list[i])))
+		}
+	}
+}
+
+// sendDecodeFailure reports a decode error.  sendResult maps the typed
+// error to an HTTP 400 in strict mode or a well-formed denial in lenient
+// mode, so the user sees the reason instead of the opaque webhook failure
+// FailurePolicy Fail would produce.
+func (ac *admissionController) sendDecodeFailure(w http.ResponseWriter, req *admissionv1beta1.AdmissionRequest, err error) {
+	if runtime.IsNotRegisteredError(err) {
+		//the install package for the kind is not linked into this binary;
+		//name the kind plainly instead of passing on the scheme's internal
+		//error text
+		gvk := schema.GroupVersionKind{Group: req.Kind.Group, Version: req.Kind.Version, Kind: req.Kind.Kind}
+		err = fmt.Errorf("no decoder is registered for %s; the controller cannot validate this object", gvk)
+	}
+	ac.sendResult(errors.NewAggregate([]error{ErrDecode(fmt.Errorf("could not decode the object under review: %s", err))}), w, req, nil)
+}
+
 func (ac *admissionController) handleSCC(w http.ResponseWriter, r *http.Request) {
-	req, errcode := getAdmissionReviewRequest(r)
-	log.Print("New SCC validation request")
+	req, errcode := ac.getAdmissionReviewRequest(w, r)
 	if errcode != 0 {
-		http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+		http.Error(w, http.StatusText(errcode), errcode)
 		return
 	}
-	if req.Operation == admissionv1beta1.Delete {
+	log.Printf("Request %s: new SCC validation request: %s %s by %s", req.UID, req.Operation, req.Name, req.UserInfo.Username)
+	if ac.verboseSCCLogging {
+		//the raw object includes the SCC's user and group lists, so it is
+		//only dumped when verbose logging is explicitly enabled
+		log.Printf("Request %s: raw SCC object: %s", req.UID, string(req.Object.Raw))
+	}
+	ac.validateSCCRequest(w, req)
+}
+
+func (ac *admissionController) validateSCCRequest(w http.ResponseWriter, req *admissionv1beta1.AdmissionRequest) {
+	switch req.Operation {
+	case admissionv1beta1.Delete:
 		//allow Delete only on SCC which are not in the protected map
-		_, protected := ac.protectedSCCs[req.Name]
+		_, protected := ac.protectedSCCTemplate(req.Name)
 		if protected {
-			errs := []error{fmt.Errorf("Deleting of this SCC is not allowed")}
-			sendResult(errors.NewAggregate(errs), w, req.UID)
+			ac.recordSCCTamperAttempt(req.Name, "delete", req.UserInfo.Username)
+			errs := []error{withReason(reasonSCCProtected, fmt.Errorf("Deleting of this SCC is not allowed"))}
+			ac.sendResult(errors.NewAggregate(errs), w, req, nil)
 		} else {
-			sendResult(nil, w, req.UID)
+			ac.sendResult(nil, w, req, nil)
 		}
 		return
+	case admissionv1beta1.Connect:
+		//Connect is not registered in the ValidatingWebhookConfiguration and
+		//carries no object to compare; allow it explicitly instead of falling
+		//through to the decode path, which is undefined for it
+		ac.sendResult(nil, w, req, nil)
+		return
+	case admissionv1beta1.Create, admissionv1beta1.Update:
+		//Create and Update are validated the same way below.  A Create whose
+		//name collides with a protected template (e.g. recreating a deleted
+		//protected SCC with weaker settings) has to match the template, with
+		//additional Users and Groups allowed, just like an Update.
+	}
+	if len(req.Object.Raw) == 0 {
+		http.Error(w, "AdmissionReview request contains no object to review", http.StatusBadRequest)
+		return
 	}
-	//if Operation is Create,Update (Connect not configured in ValidatingWebhookConfiguration)
 	gvk := schema.GroupVersionKind{Group: req.Kind.Group, Version: req.Kind.Version, Kind: req.Kind.Kind}
 	o, _, err := codec.Decode(req.Object.Raw, &gvk, nil)
 	if err != nil {
 		log.Printf("Decode error:  %s", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		ac.sendDecodeFailure(w, req, err)
 		return
 	}
-	scc := o.(*security.SecurityContextConstraints)
-	sccTemplate, protected := ac.protectedSCCs[scc.Name]
+	scc, ok := o.(*security.SecurityContextConstraints)
+	if !ok {
+		log.Printf("Unexpected decoded type %T for kind %s", o, req.Kind.Kind)
+		http.Error(w, fmt.Sprintf("unexpected decoded type for kind %s", req.Kind.Kind), http.StatusBadRequest)
+		return
+	}
+	sccTemplate, protected := ac.protectedSCCTemplate(scc.Name)
 	if protected {
 		//SCC in the set of protected SCCs
 		//only allow additional users and groups
+		//On Update the previously admitted SCC is the baseline for the
+		//Users/Groups superset check, so users and groups legitimately added
+		//by a prior update cannot be removed either.
+		if req.Operation == admissionv1beta1.Update && len(req.OldObject.Raw) > 0 {
+			oldObject, _, err := codec.Decode(req.OldObject.Raw, &gvk, nil)
+			if err != nil {
+				log.Printf("Decode error on oldObject: %s", err)
+			} else if oldScc, ok := oldObject.(*security.SecurityContextConstraints); ok {
+				sccTemplate.Users = oldScc.Users
+				sccTemplate.Groups = oldScc.Groups
+			}
+		}
 		errs := verifySCC(*scc, sccTemplate)
-		sendResult(errs, w, req.UID)
+		if errs != nil {
+			ac.recordSCCTamperAttempt(scc.Name, strings.ToLower(string(req.Operation)), req.UserInfo.Username)
+			tagged := make([]error, 0, len(errs.Errors()))
+			for _, e := range errs.Errors() {
+				tagged = append(tagged, withReason(reasonSCCProtected, e))
+			}
+			errs = errors.NewAggregate(tagged)
+		}
+		ac.sendResult(errs, w, req, nil)
 	} else {
 		//SCC not in the set of protected SCCs
+		//the privileged-creator ClusterRole lets customer admins create new
+		//SCCs; when configured, cap what those may allow unless the creator
+		//is in one of the elevated groups.  Update is covered as well, so a
+		//harmless SCC cannot be created and then escalated.
+		if ac.restrictNewSCCs && scc.AllowPrivilegedContainer && !userInGroups(req.UserInfo.Groups, ac.newSCCPrivilegedGroups) {
+			errs := []error{fmt.Errorf("Creating an SCC with allowPrivilegedContainer requires membership in one of the groups %v", ac.newSCCPrivilegedGroups)}
+			ac.sendResult(errors.NewAggregate(errs), w, req, nil)
+			return
+		}
 		//allow operation
-		sendResult(nil, w, req.UID)
+		ac.sendResult(nil, w, req, nil)
 	}
 }
 
-func imageIsWhitelisted(image string, whitelistedImages []*regexp.Regexp) bool {
-	for _, rx := range whitelistedImages {
-		if rx.MatchString(image) {
-			return true
+// userInGroups reports whether any of the user's groups is in the allowed
+// set.
+func userInGroups(userGroups []string, allowed []string) bool {
+	for _, g := range userGroups {
+		for _, a := range allowed {
+			if g == a {
+				return true
+			}
 		}
 	}
 	return false
 }
 
-// podIsWhitelisted returns true if all images of all containers are whitelisted
-func podSpecIsWhitelisted(spec *core.PodSpec, whitelistedImages []*regexp.Regexp) bool {
-	if spec.NodeSelector != nil {
-		log.Printf("NodeSelector not nil: %v", spec.NodeSelector)
-		if spec.NodeSelector["node-role.kubernetes.io/master"] == "true" || spec.NodeSelector["node-role.kubernetes.io/infra"] == "true" {
-			return true
+// canonicalImageReference normalizes an image reference to the
+// registry/repository:tag (or @digest) form the container runtime resolves
+// it to: a missing registry becomes docker.io (with the library/ namespace
+// for single-segment names), index.docker.io is folded into docker.io, and a
+// missing tag becomes :latest.
+func canonicalImageReference(image string) string {
+	if image == "" {
+		return image
+	}
+	firstSegment := image
+	if i := strings.Index(image, "/"); i >= 0 {
+		firstSegment = image[:i]
+	}
+	//a first segment without a dot or port and other than localhost is part
+	//of the repository, not a registry host
+	if firstSegment == image || (!strings.ContainsAny(firstSegment, ".:") && firstSegment != "localhost") {
+		if !strings.Contains(image, "/") {
+			image = "library/" + image
 		}
+		image = "docker.io/" + image
+	} else if firstSegment == "index.docker.io" {
+		image = "docker.io" + image[len(firstSegment):]
 	}
-	//nodeSelector is not sent in the static Pod review request, but the Node is available
-	if strings.HasPrefix(spec.NodeName, "master-") || strings.HasPrefix(spec.NodeName, "infra-") {
-		//if it's a pod assigned to a master or infra node it should be able to run
+	if strings.Contains(image, "@") {
+		return image
+	}
+	lastSegment := image[strings.LastIndex(image, "/")+1:]
+	if !strings.Contains(lastSegment, ":") {
+		image = image + ":latest"
+	}
+	return image
+}
+
+// imageIsWhitelisted returns true if the image matches any whitelistedImages
+// regular expression and none of the denylistedImages regular expressions,
+// together with the pattern which decided the outcome.  The denylist takes
+// precedence over the whitelist.  Patterns are tried against the reference
+// exactly as submitted and against its canonical form, so equivalent
+// spellings of the same image can neither dodge a denylist entry nor miss a
+// whitelist entry.
+func imageIsWhitelisted(image string, whitelistedImages, denylistedImages []*regexp.Regexp) (bool, string) {
+	//an empty image (e.g. filled in later by an image-change trigger) is
+	//never whitelisted, even by a pattern like ^.*$ which matches the empty
+	//string
+	if image == "" {
+		return false, ""
+	}
+	forms := []string{image}
+	if canonical := canonicalImageReference(image); canonical != image {
+		forms = append(forms, canonical)
+	}
+	for _, rx := range denylistedImages {
+		for _, form := range forms {
+			if rx.MatchString(form) {
+				return false, rx.String()
+			}
+		}
+	}
+	for _, rx := range whitelistedImages {
+		for _, form := range forms {
+			if rx.MatchString(form) {
+				return true, rx.String()
+			}
+		}
+	}
+	return false, ""
+}
+
+// imageIsFullyQualified reports whether the image reference pins down exactly
+// what is pulled: an explicit registry host together with a tag or digest.
+// Bare names like "regularimage" resolve via search paths and default tags
+// and are not accepted in strict mode.
+func imageIsFullyQualified(image string) bool {
+	slash := strings.Index(image, "/")
+	if slash < 0 {
+		return false
+	}
+	host := image[:slash]
+	if !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return false
+	}
+	remainder := image[slash+1:]
+	if strings.Contains(remainder, "@") {
 		return true
 	}
+	lastSegment := remainder[strings.LastIndex(remainder, "/")+1:]
+	return strings.Contains(lastSegment, ":")
+}
+
+// podSpecIsWhitelisted returns true if all images of all containers are
+// whitelisted, together with the names of the containers whose images are not
+// and the whitelist patterns which matched, for auditing
+func podSpecIsWhitelisted(spec *core.PodSpec, whitelistedImages, denylistedImages []*regexp.Regexp) (bool, []string, []string) {
+	if podIsNodeSelectorBypassed(spec) {
+		return true, nil, nil
+	}
+	whitelisted := true
+	var nonWhitelisted, matchedPatterns []string
+	seenPatterns := map[string]bool{}
 	containers := append([]core.Container{}, spec.Containers...)
 	containers = append(containers, spec.InitContainers...)
 	for _, c := range containers {
-		log.Printf("Image %s", c.Image)
-		if !imageIsWhitelisted(c.Image, whitelistedImages) {
-			return false
+		imageWhitelisted, pattern := imageIsWhitelisted(c.Image, whitelistedImages, denylistedImages)
+		if !imageWhitelisted {
+			whitelisted = false
+			nonWhitelisted = append(nonWhitelisted, c.Name)
+		} else if !seenPatterns[pattern] {
+			seenPatterns[pattern] = true
+			matchedPatterns = append(matchedPatterns, pattern)
+		}
+	}
+
+	return whitelisted, nonWhitelisted, matchedPatterns
+}
+
+// podIsNodeSelectorBypassed reports whether the pod is admitted wholesale
+// because it targets master or infra nodes.
+func podIsNodeSelectorBypassed(spec *core.PodSpec) bool {
+	if spec.NodeSelector["node-role.kubernetes.io/master"] == "true" || spec.NodeSelector["node-role.kubernetes.io/infra"] == "true" {
+		return true
+	}
+	//nodeSelector is not sent in the static Pod review request, but the Node is available
+	//if it's a pod assigned to a master or infra node it should be able to run
+	return strings.HasPrefix(spec.NodeName, "master-") || strings.HasPrefix(spec.NodeName, "infra-")
+}
+
+// securityRelevantSpecChanged reports whether any of the pod spec fields this
+// controller validates (images and their pull policies, security contexts,
+// resources, volumes, node placement, host namespaces) differ between the
+// previously admitted spec and the updated one.  Updates which leave all of
+// them untouched do not need to be validated again.
+func securityRelevantSpecChanged(old, updated *core.PodSpec) bool {
+	if len(old.Containers) != len(updated.Containers) || len(old.InitContainers) != len(updated.InitContainers) {
+		return true
+	}
+	//pull policies are constrained by whitelist profiles and resources by
+	//the reserved-resources check, so both re-trigger validation as well
+	containerChanged := func(old, updated *core.Container) bool {
+		return old.Image != updated.Image ||
+			old.ImagePullPolicy != updated.ImagePullPolicy ||
+			!reflect.DeepEqual(old.Resources, updated.Resources) ||
+			!reflect.DeepEqual(old.SecurityContext, updated.SecurityContext)
+	}
+	for i := range updated.Containers {
+		if containerChanged(&old.Containers[i], &updated.Containers[i]) {
+			return true
+		}
+	}
+	for i := range updated.InitContainers {
+		if containerChanged(&old.InitContainers[i], &updated.InitContainers[i]) {
+			return true
+		}
+	}
+	//the pod-level SecurityContext covers the host namespace flags
+	//(hostNetwork, hostPID, hostIPC) in the internal API
+	return !reflect.DeepEqual(old.SecurityContext, updated.SecurityContext) ||
+		!reflect.DeepEqual(old.Volumes, updated.Volumes) ||
+		!reflect.DeepEqual(old.NodeSelector, updated.NodeSelector) ||
+		old.NodeName != updated.NodeName ||
+		!reflect.DeepEqual(old.HostAliases, updated.HostAliases) ||
+		!reflect.DeepEqual(old.AutomountServiceAccountToken, updated.AutomountServiceAccountToken)
+}
+
+// securityRelevantAnnotationPrefixes are the annotation families the SCC
+// provider validates in this API vintage: seccomp and AppArmor profiles
+// travel as pod and per-container annotations, not spec fields.
+var securityRelevantAnnotationPrefixes = []string{
+	"seccomp.security.alpha.kubernetes.io/",
+	"container.apparmor.security.beta.kubernetes.io/",
+}
+
+// securityRelevantAnnotationsChanged reports whether any security-relevant
+// annotation was added, removed or changed between the previously admitted
+// object and the updated one.  Metadata is mutable, so a seccomp annotation
+// added to an admitted pod would otherwise slip through the unchanged-spec
+// fast-allow and unconfine the container on its next restart.
+func securityRelevantAnnotationsChanged(old, updated map[string]string) bool {
+	for _, prefix := range securityRelevantAnnotationPrefixes {
+		for k, v := range updated {
+			if strings.HasPrefix(k, prefix) && old[k] != v {
+				return true
+			}
+		}
+		for k, v := range old {
+			if strings.HasPrefix(k, prefix) && updated[k] != v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// skipLabel marks pods excluded from validation.  It is only honored when
+// the requester could have used the privileged SCC anyway; the vendored
+// admissionregistration API predates objectSelector, so the exclusion is
+// enforced here instead of in the webhook configuration.
+const skipLabel = "aro-admission-controller/skip"
+
+// requesterMayUsePrivilegedSCC checks via SubjectAccessReview whether the
+// requesting user is allowed to use the privileged SCC
+func (ac *admissionController) requesterMayUsePrivilegedSCC(userInfo authenticationv1.UserInfo) bool {
+	res, err := ac.client.Authorization().SubjectAccessReviews().Create(&authorizationapi.SubjectAccessReview{
+		Spec: authorizationapi.SubjectAccessReviewSpec{
+			User:   userInfo.Username,
+			Groups: userInfo.Groups,
+			ResourceAttributes: &authorizationapi.ResourceAttributes{
+				Verb:     "use",
+				Group:    "security.openshift.io",
+				Resource: "securitycontextconstraints",
+				Name:     "privileged",
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Error checking authorization for %s: %s", userInfo.Username, err)
+		return false
+	}
+	return res.Status.Allowed
+}
+
+// serviceAccountMayUsePrivilegedSCC reports whether the pod's service
+// account is itself allowed to use the privileged SCC, reviewed with the
+// identity and groups the service account authenticates with.
+func (ac *admissionController) serviceAccountMayUsePrivilegedSCC(namespace, serviceAccount string) bool {
+	return ac.requesterMayUsePrivilegedSCC(authenticationv1.UserInfo{
+		Username: "system:serviceaccount:" + namespace + ":" + serviceAccount,
+		Groups:   []string{"system:serviceaccounts", "system:serviceaccounts:" + namespace},
+	})
+}
+
+// forbiddenCombinationChecks maps the flag names accepted in the
+// forbiddenCombinations config to their predicates on a pod spec
+var forbiddenCombinationChecks = map[string]func(*core.PodSpec) bool{
+	"privileged": func(spec *core.PodSpec) bool {
+		containers := append([]core.Container{}, spec.Containers...)
+		containers = append(containers, spec.InitContainers...)
+		for _, c := range containers {
+			if c.SecurityContext != nil && c.SecurityContext.Privileged != nil && *c.SecurityContext.Privileged {
+				return true
+			}
+		}
+		return false
+	},
+	"hostNetwork": func(spec *core.PodSpec) bool {
+		return spec.SecurityContext != nil && spec.SecurityContext.HostNetwork
+	},
+	"hostPID": func(spec *core.PodSpec) bool {
+		return spec.SecurityContext != nil && spec.SecurityContext.HostPID
+	},
+	"hostIPC": func(spec *core.PodSpec) bool {
+		return spec.SecurityContext != nil && spec.SecurityContext.HostIPC
+	},
+}
+
+// checkForbiddenCombinations denies pod specs requesting every privilege of
+// any configured combination.  Whitelisting does not exempt a pod from these:
+// they mark blast radii no customer workload is ever meant to have.
+func (ac *admissionController) checkForbiddenCombinations(spec *core.PodSpec) field.ErrorList {
+	var errs field.ErrorList
+	for _, combination := range ac.forbiddenCombinations {
+		matched := len(combination) > 0
+		for _, flag := range combination {
+			if !forbiddenCombinationChecks[flag](spec) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			errs = append(errs, field.Forbidden(field.NewPath("spec"), fmt.Sprintf("requesting the combination of [%s] is not allowed, even for whitelisted pods", strings.Join(combination, ", "))))
 		}
 	}
+	return errs
+}
 
-	return true
+// checkWhitelistProfiles validates each container against the policy
+// profile of the whitelist pattern which matched its image, so one
+// whitelisted image can be allowed privilege while another is not.
+// Patterns without a profile keep the blanket allow.
+func (ac *admissionController) checkWhitelistProfiles(spec *core.PodSpec, whitelistedImages, denylistedImages []*regexp.Regexp) field.ErrorList {
+	profiles := ac.profilePolicies()
+	if len(profiles) == 0 {
+		return nil
+	}
+	var errs field.ErrorList
+	hostNetwork := spec.SecurityContext != nil && spec.SecurityContext.HostNetwork
+	forEachContainer(spec, func(c core.Container, path *field.Path) {
+		matched, pattern := imageIsWhitelisted(c.Image, whitelistedImages, denylistedImages)
+		if !matched {
+			//node-selector-bypassed pods reach here with unmatched images;
+			//profiles only constrain what a whitelist pattern grants
+			return
+		}
+		profile, found := profiles[pattern]
+		if !found {
+			return
+		}
+		if !profile.AllowPrivileged && c.SecurityContext != nil && c.SecurityContext.Privileged != nil && *c.SecurityContext.Privileged {
+			errs = append(errs, field.Forbidden(path.Child("securityContext", "privileged"), fmt.Sprintf("the whitelist profile for pattern %s does not allow privileged containers", pattern)))
+		}
+		if !profile.AllowHostNetwork && hostNetwork {
+			errs = append(errs, field.Forbidden(field.NewPath("spec", "securityContext", "hostNetwork"), fmt.Sprintf("the whitelist profile for pattern %s does not allow hostNetwork", pattern)))
+		}
+		if c.SecurityContext != nil && c.SecurityContext.Capabilities != nil {
+			allowed := map[string]bool{}
+			for _, capability := range profile.AllowedCapabilities {
+				allowed[strings.ToUpper(capability)] = true
+			}
+			for _, capability := range c.SecurityContext.Capabilities.Add {
+				if !allowed[strings.ToUpper(string(capability))] {
+					errs = append(errs, field.Forbidden(path.Child("securityContext", "capabilities"), fmt.Sprintf("the whitelist profile for pattern %s does not allow adding the %s capability", pattern, capability)))
+				}
+			}
+		}
+		if len(profile.AllowedPullPolicies) > 0 {
+			allowed := false
+			for _, policy := range profile.AllowedPullPolicies {
+				if core.PullPolicy(policy) == c.ImagePullPolicy {
+					allowed = true
+				}
+			}
+			if !allowed {
+				errs = append(errs, field.Forbidden(path.Child("imagePullPolicy"), fmt.Sprintf("the whitelist profile for pattern %s requires an imagePullPolicy of %s, not %s", pattern, strings.Join(profile.AllowedPullPolicies, " or "), c.ImagePullPolicy)))
+			}
+		}
+	})
+	return errs
 }
 
-func (ac *admissionController) validatePodAgainstSCC(pod *core.Pod, namespace string) (field.ErrorList, error) {
-	if podSpecIsWhitelisted(&pod.Spec, ac.whitelistedImages) {
-		log.Printf("Pod is whitelisted")
-		return nil, nil
+// checkRequiredDropCapabilities requires every container of a whitelisted
+// pod to drop the configured capabilities, as a minimal safety floor the
+// whitelist does not lift.
+func (ac *admissionController) checkRequiredDropCapabilities(spec *core.PodSpec) field.ErrorList {
+	var errs field.ErrorList
+	if len(ac.requiredDropCapabilities) == 0 {
+		return errs
 	}
-	log.Printf("Pod is not whitelisted")
-	provider, _, err := securitycontextconstraints.CreateProviderFromConstraint(namespace, nil, ac.restricted, ac.client)
+	forEachContainer(spec, func(c core.Container, path *field.Path) {
+		dropped := map[core.Capability]bool{}
+		if c.SecurityContext != nil && c.SecurityContext.Capabilities != nil {
+			for _, cap := range c.SecurityContext.Capabilities.Drop {
+				dropped[cap] = true
+			}
+		}
+		for _, required := range ac.requiredDropCapabilities {
+			if !dropped[core.Capability(required)] {
+				errs = append(errs, field.Forbidden(path.Child("securityContext", "capabilities", "drop"), fmt.Sprintf("whitelisted pods have to drop the %s capability", required)))
+			}
+		}
+	})
+	return errs
+}
+
+// validatePodAgainstSCC validates the pod, returning denial errors, the
+// whitelist patterns which allowed it (if any) and an internal error.  The
+// context bounds the API calls made while validating.
+func (ac *admissionController) validatePodAgainstSCC(ctx context.Context, pod *core.Pod, namespace string) (field.ErrorList, []string, error) {
+	whitelistedImages, denylistedImages := ac.imagePolicies()
+	whitelisted, nonWhitelisted, matchedPatterns := podSpecIsWhitelisted(&pod.Spec, whitelistedImages, denylistedImages)
+	errs := ac.checkForbiddenCombinations(&pod.Spec)
+	if whitelisted {
+		log.Printf("Pod %s/%s is whitelisted, matched patterns: %v", namespace, pod.Name, matchedPatterns)
+		errs = append(errs, ac.checkRequiredDropCapabilities(&pod.Spec)...)
+		errs = append(errs, ac.checkWhitelistProfiles(&pod.Spec, whitelistedImages, denylistedImages)...)
+		if ac.validateNodeSelectorInitContainers && podIsNodeSelectorBypassed(&pod.Spec) {
+			//the node-selector bypass admits the whole pod without looking at
+			//its images; as defense in depth, still deny privileged init
+			//containers there unless their image is whitelisted
+			for i, c := range pod.Spec.InitContainers {
+				if imageWhitelisted, _ := imageIsWhitelisted(c.Image, whitelistedImages, denylistedImages); imageWhitelisted {
+					continue
+				}
+				if c.SecurityContext != nil && c.SecurityContext.Privileged != nil && *c.SecurityContext.Privileged {
+					errs = append(errs, field.Forbidden(field.NewPath("spec", "initContainers").Index(i).Child("securityContext", "privileged"), "privileged init containers with non-whitelisted images are not allowed for node-selector-matched pods"))
+				}
+			}
+		}
+		if ac.signatureVerifier != nil {
+			forEachContainer(&pod.Spec, func(c core.Container, path *field.Path) {
+				if err := ac.signatureVerifier.Verify(c.Image); err != nil {
+					errs = append(errs, field.Forbidden(path.Child("image"), fmt.Sprintf("image signature verification failed: %s", err)))
+				}
+			})
+		}
+		if len(errs) > 0 {
+			return errs, nil, nil
+		}
+		return nil, matchedPatterns, nil
+	}
+	log.Printf("Pod %s/%s is not whitelisted, non-whitelisted containers: %v", namespace, pod.Name, nonWhitelisted)
+	if ac.rejectHostAliases && len(pod.Spec.HostAliases) > 0 {
+		errs = append(errs, field.Forbidden(field.NewPath("spec", "hostAliases"), "host aliases are not allowed for non-whitelisted pods"))
+	}
+	if ac.requireTokenAutomountDisabled && (pod.Spec.AutomountServiceAccountToken == nil || *pod.Spec.AutomountServiceAccountToken) {
+		errs = append(errs, field.Forbidden(field.NewPath("spec", "automountServiceAccountToken"), "non-whitelisted pods have to set automountServiceAccountToken to false"))
+	}
+	for _, reserved := range ac.reservedResources {
+		name := core.ResourceName(reserved)
+		forEachContainer(&pod.Spec, func(c core.Container, path *field.Path) {
+			_, requested := c.Resources.Requests[name]
+			if !requested {
+				_, requested = c.Resources.Limits[name]
+			}
+			if requested {
+				errs = append(errs, field.Forbidden(path.Child("resources"), fmt.Sprintf("resource %s is reserved for platform workloads", reserved)))
+			}
+		})
+	}
+	if podIsWindows(&pod.Spec) {
+		//evaluating the Linux restricted SCC (uid ranges, SELinux, seccomp)
+		//against a Windows workload would wrongly reject it; check only the
+		//Linux-specific privileges a Windows pod must not request
+		log.Printf("Pod %s/%s targets Windows nodes, skipping Linux SCC evaluation", namespace, pod.Name)
+		winErrs := validateWindowsPod(pod)
+		if len(winErrs) > 0 && len(nonWhitelisted) > 0 {
+			winErrs = append(winErrs, field.Forbidden(field.NewPath("spec"), fmt.Sprintf("containers [%s] use images which are not whitelisted", strings.Join(nonWhitelisted, ", "))))
+		}
+		return append(errs, winErrs...), nil, nil
+	}
+	restricted := ac.restrictedSCC()
+	if restricted == nil {
+		//without a restricted SCC there is no baseline to validate against;
+		//fail the request with a clear internal error rather than servicing
+		//reviews against a nil baseline
+		return nil, nil, fmt.Errorf("no restricted SCC is loaded to validate against")
+	}
+	ctx, cancel := context.WithTimeout(ctx, ac.validationTimeout())
+	defer cancel()
+	provider, err := ac.createProviderWithDeadline(ctx, namespace)
+	if err != nil {
+		//a namespace without the openshift.io/sa.scc.* annotations cannot
+		//produce a provider; deny with a clear message instead of failing the
+		//webhook call, which under FailurePolicy Fail would block the
+		//operation with an opaque error
+		log.Printf("Error creating SCC provider for namespace %s: %s", namespace, err)
+		return append(errs, field.Forbidden(field.NewPath("metadata", "namespace"), fmt.Sprintf("cannot validate pod against SCC %q in namespace %q: %s", restricted.Name, namespace, err))), nil, nil
+	}
+
+	//the matcher roots only its pod-level paths at the given prefix; pass a
+	//sentinel and clean the errors up afterwards, instead of faking the SCC
+	//name as a path segment
+	sccErrs := describeSCCDenials(provider.GetSCCName(), securitycontextconstraints.AssignSecurityContext(provider, pod, field.NewPath(sccBaselineSentinel)))
+	if len(sccErrs) > 0 && len(nonWhitelisted) > 0 {
+		//name the containers which caused the pod to be validated against the
+		//restricted SCC, so the denial points at the right place
+		sccErrs = append(sccErrs, field.Forbidden(field.NewPath("spec"), fmt.Sprintf("containers [%s] use images which are not whitelisted", strings.Join(nonWhitelisted, ", "))))
+	}
+	return append(errs, sccErrs...), nil, nil
+}
+
+// sccBaselineSentinel roots the pod-level paths the SCC matcher produces, so
+// describeSCCDenials can recognize and strip it again.
+const sccBaselineSentinel = "pod"
+
+// describeSCCDenials rewrites the matcher's errors so each denial carries a
+// well-formed field path and states which SCC the pod was validated against.
+// The old approach encoded the SCC name as a fake path segment ("provider
+// restricted: "), which corrupted the path for any client parsing it.
+func describeSCCDenials(sccName string, errs field.ErrorList) field.ErrorList {
+	for _, e := range errs {
+		e.Field = strings.TrimPrefix(e.Field, sccBaselineSentinel+".")
+		if e.Detail == "" {
+			e.Detail = fmt.Sprintf("validated against SCC %q", sccName)
+		} else {
+			e.Detail = fmt.Sprintf("validated against SCC %q: %s", sccName, e.Detail)
+		}
+	}
+	return errs
+}
+
+// createProviderWithDeadline creates the SCC provider for the namespace,
+// giving up when the context deadline expires.  The vendored clientset
+// predates per-call contexts, so the deadline is enforced around the lookup
+// instead of inside it.
+func (ac *admissionController) createProviderWithDeadline(ctx context.Context, namespace string) (securitycontextconstraints.SecurityContextConstraintsProvider, error) {
+	type result struct {
+		provider securitycontextconstraints.SecurityContextConstraintsProvider
+		err      error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		baseline := ac.restrictedSCC()
+		//the namespace lookup is an API call, so it runs under the same
+		//deadline as the provider creation
+		if requested := ac.namespaceBaseline(namespace); requested != nil {
+			baseline = requested
+		}
+		if len(ac.allowedUnsafeSysctls) > 0 {
+			//layer the configured sysctls on a copy of the baseline instead of
+			//mutating it; anything outside the safe set and this list is still
+			//denied by the provider's sysctl strategy
+			baseline = baseline.DeepCopy()
+			baseline.AllowedUnsafeSysctls = append(baseline.AllowedUnsafeSysctls, ac.allowedUnsafeSysctls...)
+		}
+		provider, _, err := securitycontextconstraints.CreateProviderFromConstraint(namespace, nil, baseline, ac.client)
+		ch <- result{provider, err}
+	}()
+	select {
+	case res := <-ch:
+		return res.provider, res.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("timed out looking up namespace %s: %s", namespace, ctx.Err())
+	}
+}
+
+// namespaceBaseline returns the bootstrap SCC a namespace requests through
+// the configured default-SCC annotation, or nil to keep the restricted
+// baseline.  Only names from the approved set are honored: the annotation is
+// writable by namespace admins and must not become an escape hatch to
+// privileged.
+func (ac *admissionController) namespaceBaseline(namespace string) *security.SecurityContextConstraints {
+	if ac.defaultSCCAnnotation == "" {
+		return nil
+	}
+	ns, err := ac.client.Core().Namespaces().Get(namespace, metav1.GetOptions{})
+	if err != nil {
+		log.Printf("Error reading namespace %s for the default SCC annotation: %s", namespace, err)
+		return nil
+	}
+	name := ns.Annotations[ac.defaultSCCAnnotation]
+	if name == "" {
+		return nil
+	}
+	if !ac.approvedDefaultSCCs[name] {
+		log.Printf("Namespace %s requests default SCC %s which is not in the approved set, keeping the restricted baseline", namespace, name)
+		return nil
+	}
+	scc, err := getBootstrapSCC(name)
 	if err != nil {
-		return nil, err
+		log.Printf("Namespace %s requests default SCC %s: %s; keeping the restricted baseline", namespace, name, err)
+		return nil
 	}
+	log.Printf("Namespace %s requests default SCC %s, validating against it", namespace, name)
+	return scc
+}
 
-	return securitycontextconstraints.AssignSecurityContext(provider, pod, field.NewPath(fmt.Sprintf("provider %s: ", provider.GetSCCName()))), nil
+// trustedOwner returns the first controller ownerReference matching the
+// configured trusted kinds.  Only references marked controller count: a
+// stray non-controller reference must not grant trust.  A matching
+// reference additionally has to resolve to a live object: ownerReferences
+// are client-settable and unverified by the API server, so without the
+// lookup any pod author could forge one and skip validation.
+func (ac *admissionController) trustedOwner(meta *metav1.ObjectMeta, namespace string) (metav1.OwnerReference, bool) {
+	for _, ref := range meta.OwnerReferences {
+		if ref.Controller == nil || !*ref.Controller {
+			continue
+		}
+		for _, trusted := range ac.trustedOwners {
+			kind := trusted
+			if i := strings.Index(trusted, "/"); i >= 0 {
+				if trusted[:i] != namespace {
+					continue
+				}
+				kind = trusted[i+1:]
+			}
+			if ref.Kind == kind && ac.ownerExists(namespace, ref) {
+				return ref, true
+			}
+		}
+	}
+	return metav1.OwnerReference{}, false
 }
 
-func getAdmissionReviewRequest(r *http.Request) (req *admissionv1beta1.AdmissionRequest, errorcode int) {
+// ownerExists reports whether the referenced object is live in the cluster
+// with the UID the reference claims.  The trusted kind may be a CRD, so the
+// lookup goes through the dynamic client with the conventional
+// kind-to-resource pluralization; without an owner client nothing can be
+// verified and no trust is granted.
+func (ac *admissionController) ownerExists(namespace string, ref metav1.OwnerReference) bool {
+	if ac.ownerClient == nil {
+		return false
+	}
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		log.Printf("Cannot parse apiVersion %s of ownerReference %s %s: %s", ref.APIVersion, ref.Kind, ref.Name, err)
+		return false
+	}
+	gvr, _ := apimeta.UnsafeGuessKindToResource(gv.WithKind(ref.Kind))
+	owner, err := ac.ownerClient.Resource(gvr).Namespace(namespace).Get(ref.Name, metav1.GetOptions{})
+	if err != nil {
+		//the trusted kind may be cluster-scoped, which the guessed mapping
+		//cannot tell; retry unnamespaced before giving up
+		owner, err = ac.ownerClient.Resource(gvr).Get(ref.Name, metav1.GetOptions{})
+	}
+	if err != nil {
+		log.Printf("Cannot verify ownerReference %s %s/%s: %s", ref.Kind, namespace, ref.Name, err)
+		return false
+	}
+	return owner.GetUID() == ref.UID
+}
+
+// unsupportedSecurityFieldViolations scans the raw object for the security
+// fields the vendored internal types predate: Windows hostProcess containers
+// and a non-default procMount.  The walk covers every securityContext in the
+// document, so pod templates nested in workload kinds are found as well.
+func unsupportedSecurityFieldViolations(raw []byte) []error {
+	var o interface{}
+	if err := json.Unmarshal(raw, &o); err != nil {
+		return nil
+	}
+	var errs []error
+	scanSecurityContexts(o, &errs)
+	return errs
+}
+
+func scanSecurityContexts(o interface{}, errs *[]error) {
+	switch v := o.(type) {
+	case map[string]interface{}:
+		if sc, ok := v["securityContext"].(map[string]interface{}); ok {
+			if wo, ok := sc["windowsOptions"].(map[string]interface{}); ok {
+				if hostProcess, ok := wo["hostProcess"].(bool); ok && hostProcess {
+					*errs = append(*errs, fmt.Errorf("Windows hostProcess containers are not allowed for non-whitelisted pods"))
+				}
+			}
+			if procMount, ok := sc["procMount"].(string); ok && procMount != "" && procMount != "Default" {
+				*errs = append(*errs, fmt.Errorf("procMount %s is not allowed for non-whitelisted pods", procMount))
+			}
+		}
+		for _, child := range v {
+			scanSecurityContexts(child, errs)
+		}
+	case []interface{}:
+		for _, child := range v {
+			scanSecurityContexts(child, errs)
+		}
+	}
+}
+
+// podIsWindows reports whether the pod targets Windows nodes.  The internal
+// API in this tree predates spec.os, so detection relies on the os node
+// selector labels.
+func podIsWindows(spec *core.PodSpec) bool {
+	return spec.NodeSelector["kubernetes.io/os"] == "windows" || spec.NodeSelector["beta.kubernetes.io/os"] == "windows"
+}
+
+// validateWindowsPod denies the Linux-only privileges which have no defined
+// meaning on Windows nodes and must not be requested
+func validateWindowsPod(pod *core.Pod) field.ErrorList {
+	var errs field.ErrorList
+	if pod.Spec.SecurityContext != nil && (pod.Spec.SecurityContext.HostNetwork || pod.Spec.SecurityContext.HostPID || pod.Spec.SecurityContext.HostIPC) {
+		errs = append(errs, field.Forbidden(field.NewPath("spec", "securityContext"), "host namespaces are not allowed for Windows pods"))
+	}
+	forEachContainer(&pod.Spec, func(c core.Container, path *field.Path) {
+		if c.SecurityContext != nil && c.SecurityContext.Privileged != nil && *c.SecurityContext.Privileged {
+			errs = append(errs, field.Forbidden(path.Child("securityContext", "privileged"), "privileged containers are not allowed for Windows pods"))
+		}
+	})
+	return errs
+}
+
+// forEachContainer invokes fn for every main and init container with the
+// field path pointing at the container's real location in the spec, so init
+// container violations are not misreported under spec.containers.
+func forEachContainer(spec *core.PodSpec, fn func(c core.Container, path *field.Path)) {
+	for i, c := range spec.Containers {
+		fn(c, field.NewPath("spec", "containers").Index(i))
+	}
+	for i, c := range spec.InitContainers {
+		fn(c, field.NewPath("spec", "initContainers").Index(i))
+	}
+}
+
+func (ac *admissionController) getAdmissionReviewRequest(w http.ResponseWriter, r *http.Request) (req *admissionv1beta1.AdmissionRequest, errorcode int) {
 	log.Printf("New review request %s", r.RequestURI)
 	if r.Method != http.MethodPost {
 		return nil, http.StatusMethodNotAllowed
 	}
-	if r.Header.Get("Content-Type") != "application/json" {
+	contentType := r.Header.Get("Content-Type")
+	if contentType != "application/json" && contentType != "application/yaml" {
 		return nil, http.StatusUnsupportedMediaType
 	}
 
+	reader := io.Reader(http.MaxBytesReader(w, r.Body, ac.maxRequestBytes()))
+	if strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+		//large objects may arrive compressed; the size cap above applies to
+		//the wire bytes, so the decompressed stream is capped as well to keep
+		//a small compressed body from expanding without bound
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, http.StatusBadRequest
+		}
+		defer gz.Close()
+		reader = io.LimitReader(gz, ac.maxRequestBytes()+1)
+	}
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		if err.Error() == "http: request body too large" {
+			return nil, http.StatusRequestEntityTooLarge
+		}
+		return nil, http.StatusBadRequest
+	}
+	if int64(len(body)) > ac.maxRequestBytes() {
+		return nil, http.StatusRequestEntityTooLarge
+	}
+	if contentType == "application/yaml" {
+		//some test tooling and proxies submit YAML; it is converted to JSON
+		//up front and the response is always JSON
+		body, err = yaml.YAMLToJSON(body)
+		if err != nil {
+			return nil, http.StatusBadRequest
+		}
+	}
+
 	var reviewIncoming *admissionv1beta1.AdmissionReview
-	err := json.NewDecoder(r.Body).Decode(&reviewIncoming)
+	err = json.Unmarshal(body, &reviewIncoming)
 	if err != nil {
 		return nil, http.StatusBadRequest
 	}
+	if reviewIncoming == nil || reviewIncoming.Request == nil {
+		return nil, http.StatusBadRequest
+	}
 	req = reviewIncoming.Request
 	return req, 0
 }
 
 func (ac *admissionController) handleWhitelist(w http.ResponseWriter, r *http.Request) {
-	unpackers := map[string]func(runtime.Object) (core.PodSpec, metav1.ObjectMeta, string){
-		"Pod": func(o runtime.Object) (core.PodSpec, metav1.ObjectMeta, string) {
-			pod := o.(*core.Pod)
-			return pod.Spec, pod.ObjectMeta, pod.Namespace
+	req, errcode := ac.getAdmissionReviewRequest(w, r)
+	if errcode != 0 {
+		http.Error(w, http.StatusText(errcode), errcode)
+		return
+	}
+	ac.validateWhitelistRequest(r.Context(), w, req)
+}
+
+// handleValidate is a generic endpoint dispatching on the resource under
+// review, so new kinds only need an unpacker entry and a webhook rule, not a
+// new mux path.  The per-resource paths are kept for compatibility with old
+// webhook configurations.
+func (ac *admissionController) handleValidate(w http.ResponseWriter, r *http.Request) {
+	req, errcode := ac.getAdmissionReviewRequest(w, r)
+	if errcode != 0 {
+		http.Error(w, http.StatusText(errcode), errcode)
+		return
+	}
+	switch req.Resource.Resource {
+	case "securitycontextconstraints":
+		ac.validateSCCRequest(w, req)
+	default:
+		ac.validateWhitelistRequest(r.Context(), w, req)
+	}
+}
+
+func (ac *admissionController) validateWhitelistRequest(ctx context.Context, w http.ResponseWriter, req *admissionv1beta1.AdmissionRequest) {
+	//the unpackers type-switch on the decoded internal type rather than
+	//asserting it: the apps group workloads (DaemonSet, ReplicaSet,
+	//Deployment) decode to the extensions internal types in this tree, but an
+	//unexpected representation must yield a clean error, not a panic
+	unpackers := map[string]func(runtime.Object) (core.PodSpec, metav1.ObjectMeta, string, bool){
+		"Pod": func(o runtime.Object) (core.PodSpec, metav1.ObjectMeta, string, bool) {
+			if pod, ok := o.(*core.Pod); ok {
+				return pod.Spec, pod.ObjectMeta, pod.Namespace, true
+			}
+			return core.PodSpec{}, metav1.ObjectMeta{}, "", false
 		},
-		"DaemonSet": func(o runtime.Object) (core.PodSpec, metav1.ObjectMeta, string) {
-			ds := o.(*extensions.DaemonSet)
-			return ds.Spec.Template.Spec, ds.Spec.Template.ObjectMeta, ds.Namespace
+		"DaemonSet": func(o runtime.Object) (core.PodSpec, metav1.ObjectMeta, string, bool) {
+			if ds, ok := o.(*extensions.DaemonSet); ok {
+				return ds.Spec.Template.Spec, ds.Spec.Template.ObjectMeta, ds.Namespace, true
+			}
+			return core.PodSpec{}, metav1.ObjectMeta{}, "", false
 		},
-		"ReplicaSet": func(o runtime.Object) (core.PodSpec, metav1.ObjectMeta, string) {
-			rs := o.(*extensions.ReplicaSet)
-			return rs.Spec.Template.Spec, rs.Spec.Template.ObjectMeta, rs.Namespace
+		"ReplicaSet": func(o runtime.Object) (core.PodSpec, metav1.ObjectMeta, string, bool) {
+			if rs, ok := o.(*extensions.ReplicaSet); ok {
+				return rs.Spec.Template.Spec, rs.Spec.Template.ObjectMeta, rs.Namespace, true
+			}
+			return core.PodSpec{}, metav1.ObjectMeta{}, "", false
 		},
-		"StatefulSet": func(o runtime.Object) (core.PodSpec, metav1.ObjectMeta, string) {
-			ss := o.(*apps.StatefulSet)
-			return ss.Spec.Template.Spec, ss.Spec.Template.ObjectMeta, ss.Namespace
+		"StatefulSet": func(o runtime.Object) (core.PodSpec, metav1.ObjectMeta, string, bool) {
+			if ss, ok := o.(*apps.StatefulSet); ok {
+				return ss.Spec.Template.Spec, ss.Spec.Template.ObjectMeta, ss.Namespace, true
+			}
+			return core.PodSpec{}, metav1.ObjectMeta{}, "", false
+		},
+		"Job": func(o runtime.Object) (core.PodSpec, metav1.ObjectMeta, string, bool) {
+			if job, ok := o.(*batch.Job); ok {
+				return job.Spec.Template.Spec, job.Spec.Template.ObjectMeta, job.Namespace, true
+			}
+			return core.PodSpec{}, metav1.ObjectMeta{}, "", false
 		},
-		"Job": func(o runtime.Object) (core.PodSpec, metav1.ObjectMeta, string) {
-			job := o.(*batch.Job)
-			return job.Spec.Template.Spec, job.Spec.Template.ObjectMeta, job.Namespace
+		"CronJob": func(o runtime.Object) (core.PodSpec, metav1.ObjectMeta, string, bool) {
+			if cj, ok := o.(*batch.CronJob); ok {
+				return cj.Spec.JobTemplate.Spec.Template.Spec, cj.Spec.JobTemplate.Spec.Template.ObjectMeta, cj.Namespace, true
+			}
+			return core.PodSpec{}, metav1.ObjectMeta{}, "", false
 		},
-		"CronJob": func(o runtime.Object) (core.PodSpec, metav1.ObjectMeta, string) {
-			cj := o.(*batch.CronJob)
-			return cj.Spec.JobTemplate.Spec.Template.Spec, cj.Spec.JobTemplate.Spec.Template.ObjectMeta, cj.Namespace
+		//ReplicationControllers are reviewed so that a DeploymentConfig
+		//image-change trigger swapping in a privileged image after the DC was
+		//admitted is still caught when the deployer creates the RC
+		"ReplicationController": func(o runtime.Object) (core.PodSpec, metav1.ObjectMeta, string, bool) {
+			if rc, ok := o.(*core.ReplicationController); ok {
+				if rc.Spec.Template == nil {
+					return core.PodSpec{}, metav1.ObjectMeta{}, rc.Namespace, true
+				}
+				return rc.Spec.Template.Spec, rc.Spec.Template.ObjectMeta, rc.Namespace, true
+			}
+			return core.PodSpec{}, metav1.ObjectMeta{}, "", false
 		},
-		"DeploymentConfig": func(o runtime.Object) (core.PodSpec, metav1.ObjectMeta, string) {
-			dc := o.(*oapps.DeploymentConfig)
-			return dc.Spec.Template.Spec, dc.Spec.Template.ObjectMeta, dc.Namespace
+		"DeploymentConfig": func(o runtime.Object) (core.PodSpec, metav1.ObjectMeta, string, bool) {
+			if dc, ok := o.(*oapps.DeploymentConfig); ok {
+				return dc.Spec.Template.Spec, dc.Spec.Template.ObjectMeta, dc.Namespace, true
+			}
+			return core.PodSpec{}, metav1.ObjectMeta{}, "", false
 		},
-		"Deployment": func(o runtime.Object) (core.PodSpec, metav1.ObjectMeta, string) {
-			dp := o.(*extensions.Deployment)
-			return dp.Spec.Template.Spec, dp.Spec.Template.ObjectMeta, dp.Namespace
+		"Deployment": func(o runtime.Object) (core.PodSpec, metav1.ObjectMeta, string, bool) {
+			if dp, ok := o.(*extensions.Deployment); ok {
+				return dp.Spec.Template.Spec, dp.Spec.Template.ObjectMeta, dp.Namespace, true
+			}
+			return core.PodSpec{}, metav1.ObjectMeta{}, "", false
 		},
 	}
-	req, errcode := getAdmissionReviewRequest(r)
-	if errcode != 0 {
-		http.Error(w, http.StatusText(errcode), errcode)
-		return
-	}
 	if req.UID == "" || req.Kind.Version == "" || req.Kind.Kind == "" {
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
+	if len(req.Object.Raw) == 0 {
+		http.Error(w, "AdmissionReview request contains no object to review", http.StatusBadRequest)
+		return
+	}
+	if pattern, exempted := ac.userIsExempted(req.UserInfo.Username); exempted {
+		//trusted add-on service accounts run privileged without image
+		//whitelisting; record the identity and the pattern in the audit trail
+		log.Printf("Request %s: user %s exempted by pattern %s", req.UID, req.UserInfo.Username, pattern)
+		ac.sendResult(nil, w, req, map[string]string{
+			"aro-admission-controller/exempted-user": req.UserInfo.Username,
+		})
+		return
+	}
+	if req.SubResource == "ephemeralcontainers" {
+		ac.validateEphemeralContainersRequest(ctx, w, req)
+		return
+	}
+	if req.SubResource == "scale" {
+		//scaling does not change the pod template, which was already
+		//validated on the parent resource; allow it explicitly instead of
+		//failing in the unpackers, should a webhook rule for the subresource
+		//ever be registered
+		log.Printf("Request %s: scale subresource, allowing", req.UID)
+		ac.sendResult(nil, w, req, nil)
+		return
+	}
 	gvk := schema.GroupVersionKind{Group: req.Kind.Group, Version: req.Kind.Version, Kind: req.Kind.Kind}
 	o, gvkDecoded, err := codec.Decode(req.Object.Raw, &gvk, nil)
 	if err != nil {
-		log.Printf("Decode error:  %s", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		log.Printf("Request %s decode error: %s", req.UID, err)
+		ac.sendDecodeFailure(w, req, err)
 		return
 	}
 	unpackingFunc, found := unpackers[gvkDecoded.Kind]
 	if !found {
 		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+		return
+	}
+	spec, meta, namespace, ok := unpackingFunc(o)
+	if !ok {
+		log.Printf("Unexpected decoded type %T for kind %s", o, gvkDecoded.Kind)
+		http.Error(w, fmt.Sprintf("unexpected decoded type for kind %s", gvkDecoded.Kind), http.StatusBadRequest)
+		return
 	}
-	spec, meta, namespace := unpackingFunc(o)
+	if ac.rejectPodOverhead && gvkDecoded.Kind == "Pod" {
+		//the vendored core types predate spec.overhead, so its presence is
+		//read from the raw object
+		var raw struct {
+			Spec struct {
+				Overhead map[string]string `json:"overhead"`
+			} `json:"spec"`
+		}
+		if err := json.Unmarshal(req.Object.Raw, &raw); err == nil && len(raw.Spec.Overhead) > 0 {
+			whitelistedImages, denylistedImages := ac.imagePolicies()
+			if whitelisted, _, _ := podSpecIsWhitelisted(&spec, whitelistedImages, denylistedImages); !whitelisted {
+				ac.sendResult(errors.NewAggregate([]error{fmt.Errorf("setting spec.overhead is not allowed for non-whitelisted pods")}), w, req, nil)
+				return
+			}
+		}
+	}
+	//the vendored core types also predate windowsOptions.hostProcess and
+	//procMount; both weaken the container's isolation from the host, so
+	//their presence is scanned for in the raw object and denied for
+	//non-whitelisted pods, wherever the pod template is nested
+	if violations := unsupportedSecurityFieldViolations(req.Object.Raw); len(violations) > 0 {
+		whitelistedImages, denylistedImages := ac.imagePolicies()
+		if whitelisted, _, _ := podSpecIsWhitelisted(&spec, whitelistedImages, denylistedImages); !whitelisted {
+			ac.sendResult(errors.NewAggregate(violations), w, req, nil)
+			return
+		}
+	}
+	if ac.honorSkipLabel && meta.Labels[skipLabel] == "true" {
+		if ac.requesterMayUsePrivilegedSCC(req.UserInfo) {
+			log.Printf("Request %s: pod labeled %s by authorized user %s, skipping validation", req.UID, skipLabel, req.UserInfo.Username)
+			ac.sendResult(nil, w, req, map[string]string{
+				"aro-admission-controller/skipped": "true",
+			})
+			return
+		}
+		log.Printf("Request %s: pod labeled %s but user %s is not allowed to use the privileged SCC, validating", req.UID, skipLabel, req.UserInfo.Username)
+	}
+	if len(spec.Containers) == 0 && len(spec.InitContainers) == 0 {
+		//a zero-valued pod template (e.g. a CronJob without a jobTemplate)
+		//has nothing to validate and must not slip through as an allow
+		http.Error(w, fmt.Sprintf("%s contains no pod template to review", gvkDecoded.Kind), http.StatusBadRequest)
+		return
+	}
+
+	//an Update whose security-relevant fields are unchanged is re-reviewing a
+	//spec which was already admitted, so skip the SCC evaluation for it.
+	//Controller-level fields like a DaemonSet's updateStrategy or a
+	//Deployment's replicas live outside the unpacked pod template, so
+	//updates touching only those fast-allow here as well.  The template
+	//annotations are compared too, because seccomp and AppArmor profiles
+	//live there rather than in the spec
+	if req.Operation == admissionv1beta1.Update && len(req.OldObject.Raw) > 0 {
+		oldObject, oldGVK, err := codec.Decode(req.OldObject.Raw, &gvk, nil)
+		if err != nil {
+			log.Printf("Decode error on oldObject: %s", err)
+		} else if oldGVK.Kind == gvkDecoded.Kind {
+			oldSpec, oldMeta, _, oldOK := unpackingFunc(oldObject)
+			if oldOK && !securityRelevantSpecChanged(&oldSpec, &spec) &&
+				!securityRelevantAnnotationsChanged(oldMeta.Annotations, meta.Annotations) {
+				log.Printf("Request %s: security-relevant fields unchanged on update, allowing", req.UID)
+				ac.sendResult(nil, w, req, nil)
+				return
+			}
+		}
+	}
+
+	ac.checkPodSpec(ctx, spec, meta, namespace, w, req)
+}
+
+// validateEphemeralContainersRequest validates a pods/ephemeralcontainers
+// subresource update, as sent for kubectl debug containers.  The internal API
+// in this tree predates ephemeral containers, so the fields under review are
+// decoded directly from the JSON and validated like regular containers.
+func (ac *admissionController) validateEphemeralContainersRequest(ctx context.Context, w http.ResponseWriter, req *admissionv1beta1.AdmissionRequest) {
+	var pod struct {
+		Metadata struct {
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+		Spec struct {
+			NodeSelector        map[string]string `json:"nodeSelector"`
+			NodeName            string            `json:"nodeName"`
+			EphemeralContainers []struct {
+				Name            string `json:"name"`
+				Image           string `json:"image"`
+				SecurityContext *struct {
+					Privileged *bool `json:"privileged"`
+				} `json:"securityContext"`
+			} `json:"ephemeralContainers"`
+		} `json:"spec"`
+	}
+	err := json.Unmarshal(req.Object.Raw, &pod)
+	if err != nil {
+		log.Printf("Request %s decode error on ephemeralcontainers request: %s", req.UID, err)
+		ac.sendDecodeFailure(w, req, err)
+		return
+	}
+	spec := core.PodSpec{
+		NodeSelector: pod.Spec.NodeSelector,
+		NodeName:     pod.Spec.NodeName,
+	}
+	for _, ec := range pod.Spec.EphemeralContainers {
+		c := core.Container{Name: ec.Name, Image: ec.Image}
+		if ec.SecurityContext != nil {
+			c.SecurityContext = &core.SecurityContext{Privileged: ec.SecurityContext.Privileged}
+		}
+		spec.Containers = append(spec.Containers, c)
+	}
+	namespace := pod.Metadata.Namespace
+	if namespace == "" {
+		namespace = req.Namespace
+	}
+	ac.checkPodSpec(ctx, spec, metav1.ObjectMeta{}, namespace, w, req)
+}
+
+// handlePodExec reviews pods/exec requests.  When configured, exec into a
+// pod running privileged containers is denied unless the requesting user is
+// in one of the configured groups; everything else is allowed.
+func (ac *admissionController) handlePodExec(w http.ResponseWriter, r *http.Request) {
+	req, errcode := ac.getAdmissionReviewRequest(w, r)
+	if errcode != 0 {
+		http.Error(w, http.StatusText(errcode), errcode)
+		return
+	}
+	if !ac.restrictExecToPrivileged {
+		ac.sendResult(nil, w, req, nil)
+		return
+	}
+	if userInGroups(req.UserInfo.Groups, ac.execPrivilegedGroups) {
+		ac.sendResult(nil, w, req, nil)
+		return
+	}
+	//the exec request carries only PodExecOptions; the privilege of the
+	//target pod has to be looked up
+	pod, err := ac.client.Core().Pods(req.Namespace).Get(req.Name, metav1.GetOptions{})
+	if err != nil {
+		//do not break exec outright when the lookup fails; log and allow
+		log.Printf("Request %s: error looking up pod %s/%s for exec review, allowing: %s", req.UID, req.Namespace, req.Name, err)
+		ac.sendResult(nil, w, req, nil)
+		return
+	}
+	privileged := false
+	forEachContainer(&pod.Spec, func(c core.Container, path *field.Path) {
+		if c.SecurityContext != nil && c.SecurityContext.Privileged != nil && *c.SecurityContext.Privileged {
+			privileged = true
+		}
+	})
+	if privileged {
+		log.Printf("Request %s: denying exec into privileged pod %s/%s for user %s", req.UID, req.Namespace, req.Name, req.UserInfo.Username)
+		ac.sendResult(errors.NewAggregate([]error{fmt.Errorf("exec into privileged pods is restricted to the groups %v", ac.execPrivilegedGroups)}), w, req, nil)
+		return
+	}
+	ac.sendResult(nil, w, req, nil)
+}
 
-	ac.checkPodSpec(spec, meta, namespace, w, req.UID)
+// sccDefaultingWarnings describes the security context defaults the real SCC
+// admission will apply to fields the submitted spec left unset, so customers
+// learn their pod will run differently than specified.
+func sccDefaultingWarnings(original, mutated *core.PodSpec) []string {
+	var warnings []string
+	if original.SecurityContext == nil && mutated.SecurityContext != nil {
+		sc := mutated.SecurityContext
+		if sc.FSGroup != nil {
+			warnings = append(warnings, fmt.Sprintf("the SCC will default fsGroup to %d", *sc.FSGroup))
+		}
+		if len(sc.SupplementalGroups) > 0 {
+			warnings = append(warnings, fmt.Sprintf("the SCC will default supplementalGroups to %v", sc.SupplementalGroups))
+		}
+		if sc.SELinuxOptions != nil {
+			warnings = append(warnings, "the SCC will default the pod seLinuxOptions")
+		}
+	}
+	describe := func(name string, original, mutated *core.SecurityContext) {
+		mutatedRunAsUser := mutated != nil && mutated.RunAsUser != nil && (original == nil || original.RunAsUser == nil)
+		if mutatedRunAsUser {
+			warnings = append(warnings, fmt.Sprintf("the SCC will default runAsUser to %d for container %q", *mutated.RunAsUser, name))
+		}
+		mutatedSELinux := mutated != nil && mutated.SELinuxOptions != nil && (original == nil || original.SELinuxOptions == nil)
+		if mutatedSELinux {
+			warnings = append(warnings, fmt.Sprintf("the SCC will default seLinuxOptions for container %q", name))
+		}
+		mutatedCapabilities := mutated != nil && mutated.Capabilities != nil && (original == nil || !reflect.DeepEqual(original.Capabilities, mutated.Capabilities))
+		if mutatedCapabilities {
+			warnings = append(warnings, fmt.Sprintf("the SCC will adjust the capabilities of container %q", name))
+		}
+	}
+	for i := range mutated.Containers {
+		if i < len(original.Containers) {
+			describe(mutated.Containers[i].Name, original.Containers[i].SecurityContext, mutated.Containers[i].SecurityContext)
+		}
+	}
+	for i := range mutated.InitContainers {
+		if i < len(original.InitContainers) {
+			describe(mutated.InitContainers[i].Name, original.InitContainers[i].SecurityContext, mutated.InitContainers[i].SecurityContext)
+		}
+	}
+	return warnings
 }
 
-//checkPodSpec checks if the Pod spec is either whitelisted or will match the restricted scc, then prepares an HTTP response
+// checkPodSpec checks if the Pod spec is either whitelisted or will match the restricted scc, then prepares an HTTP response
 // interface{} is used to allow core.Pod from both the Openshift and Kubernetes APIs
-func (ac *admissionController) checkPodSpec(podSpec core.PodSpec, oMeta metav1.ObjectMeta, namespace string, w http.ResponseWriter, uid types.UID) {
+func (ac *admissionController) checkPodSpec(ctx context.Context, podSpec core.PodSpec, oMeta metav1.ObjectMeta, namespace string, w http.ResponseWriter, req *admissionv1beta1.AdmissionRequest) {
 	pod := new(core.Pod)
 	podSpec.DeepCopyInto(&pod.Spec)
 	oMeta.DeepCopyInto(&pod.ObjectMeta)
-	errs, err := ac.validatePodAgainstSCC(pod, namespace)
-	if err != nil {
-		log.Printf("Validation error: %s", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if serviceName, serviceNamespace := ac.webhookService(); namespace == serviceNamespace && oMeta.Labels["app"] == serviceName {
+		//the controller must never deadlock reviewing its own pods, even if
+		//the namespace exclusion label was stripped from its namespace
+		log.Printf("Request %s: pod belongs to the admission controller itself, allowing", req.UID)
+		ac.sendResult(nil, w, req, map[string]string{
+			"aro-admission-controller/self": "true",
+		})
 		return
 	}
-	log.Printf("Review complete")
-	sendResult(errs.ToAggregate(), w, uid)
+	if owner, trusted := ac.trustedOwner(&oMeta, namespace); trusted {
+		log.Printf("Request %s: pod controller-owned by trusted %s %s, allowing", req.UID, owner.Kind, owner.Name)
+		ac.sendResult(nil, w, req, map[string]string{
+			"aro-admission-controller/trusted-owner": owner.Kind + "/" + owner.Name,
+		})
+		return
+	}
+	if ac.honorPrivilegedSCCBindings && podSpec.ServiceAccountName != "" {
+		if ac.serviceAccountMayUsePrivilegedSCC(namespace, podSpec.ServiceAccountName) {
+			//the cluster admin granted this service account the privileged
+			//SCC explicitly; respect the binding like vanilla OpenShift would
+			log.Printf("Request %s: service account %s/%s is bound to the privileged SCC, allowing", req.UID, namespace, podSpec.ServiceAccountName)
+			ac.sendResult(nil, w, req, map[string]string{
+				"aro-admission-controller/privileged-scc-binding": namespace + "/" + podSpec.ServiceAccountName,
+			})
+			return
+		}
+	}
+	var cacheKey string
+	if ac.decisionCache != nil {
+		cacheKey = decisionCacheKey(&pod.Spec, namespace)
+	}
+	errs, matchedPatterns, warnings, cached := ac.cachedDecision(cacheKey)
+	if cached {
+		log.Printf("Request %s: decision served from cache", req.UID)
+	} else {
+		var err error
+		errs, matchedPatterns, err = ac.validatePodAgainstSCC(ctx, pod, namespace)
+		if err != nil {
+			log.Printf("Request %s validation error: %s", req.UID, err)
+			ac.sendResult(errors.NewAggregate([]error{ErrInternal(err)}), w, req, nil)
+			return
+		}
+		if ac.warnOnSCCDefaults {
+			//AssignSecurityContext mutates the copy in pod, so comparing it
+			//with the submitted spec reveals the defaults the real SCC
+			//admission will apply
+			warnings = sccDefaultingWarnings(&podSpec, &pod.Spec)
+		}
+		ac.storeDecision(cacheKey, errs, matchedPatterns, warnings)
+	}
+	if ac.requireFullyQualifiedImages && len(matchedPatterns) > 0 {
+		//whitelisted workloads keep their elevated privileges, so in strict
+		//mode their images have to be pinned to exactly what was reviewed
+		forEachContainer(&pod.Spec, func(c core.Container, path *field.Path) {
+			if !imageIsFullyQualified(c.Image) {
+				errs = append(errs, field.Invalid(path.Child("image"), c.Image, "whitelisted images have to be fully qualified (registry/repository:tag or @digest)"))
+			}
+		})
+	}
+	auditAnnotations := map[string]string{}
+	if len(matchedPatterns) > 0 {
+		//record which whitelist rules permitted the workload, so the decision
+		//can be traced in the audit log
+		log.Printf("Request %s allowed by whitelist patterns %v", req.UID, matchedPatterns)
+		auditAnnotations["aro-admission-controller/matched-rule"] = strings.Join(matchedPatterns, ",")
+	}
+	if len(errs) == 0 && len(warnings) > 0 {
+		//the vendored AdmissionResponse predates response warnings, so the
+		//customer-visible note travels as an audit annotation instead
+		auditAnnotations["aro-admission-controller/warnings"] = strings.Join(warnings, "; ")
+	}
+	log.Printf("Request %s review complete", req.UID)
+	ac.sendResult(errs.ToAggregate(), w, req, auditAnnotations)
+}
+
+// admissionResponse carries the auditAnnotations field the vendored
+// admission API predates.  Like the other fields this tree's API types are
+// missing, it is handled at the JSON level instead of patching generated
+// vendor code, so the vendor tree stays exactly what glide delivers.
+type admissionResponse struct {
+	*admissionv1beta1.AdmissionResponse `json:",inline"`
+	AuditAnnotations                    map[string]string `json:"auditAnnotations,omitempty"`
 }
 
-func sendResult(errs errors.Aggregate, w http.ResponseWriter, uid types.UID) {
+// admissionReview is the AdmissionReview shape sendResult encodes, with the
+// response swapped for the annotated admissionResponse.
+type admissionReview struct {
+	metav1.TypeMeta `json:",inline"`
+	Response        *admissionResponse `json:"response,omitempty"`
+}
+
+func (ac *admissionController) sendResult(errs errors.Aggregate, w http.ResponseWriter, req *admissionv1beta1.AdmissionRequest, auditAnnotations map[string]string) {
 	result := &metav1.Status{
 		Status: metav1.StatusSuccess,
 	}
+	//the decision and its reason are recorded as audit annotations on every
+	//response, so the API server audit log keeps a durable trail of this
+	//controller's decisions
+	annotations := map[string]string{
+		"aro-admission-controller/decision": "allow",
+	}
+	for k, v := range auditAnnotations {
+		annotations[k] = v
+	}
 	if errs != nil && len(errs.Errors()) > 0 {
-		log.Printf("Found %d errs when validating", len(errs.Errors()))
-		log.Printf("Error:%s", errs.Error())
+		switch aggregateCategory(errs) {
+		case categoryInternal:
+			//internal errors are not denials; fail the request so the API
+			//server retries instead of recording a decision
+			w.Header().Set(requestUIDHeader, string(req.UID))
+			http.Error(w, errs.Error(), http.StatusInternalServerError)
+			return
+		case categoryDecode:
+			if !ac.lenientDecode {
+				w.Header().Set(requestUIDHeader, string(req.UID))
+				http.Error(w, errs.Error(), http.StatusBadRequest)
+				return
+			}
+			//in lenient mode a decode failure falls through to a regular
+			//denial, so the user sees the reason
+		}
+		log.Printf("Request %s: denying %s %s %s/%s: %d violations", req.UID, req.Operation, req.Kind.Kind, req.Namespace, req.Name, len(errs.Errors()))
+		log.Printf("Request %s error: %s", req.UID, errs.Error())
+		//the configured prefix and suffix wrap the message shown to the
+		//customer, the reason annotation keeps the bare errors
+		message := errs.Error()
+		if ac.denialMessagePrefix != "" {
+			message = ac.denialMessagePrefix + " " + message
+		}
+		if ac.denialMessageSuffix != "" {
+			message = message + " " + ac.denialMessageSuffix
+		}
 		result = &metav1.Status{
 			Status:  metav1.StatusFailure,
-			Message: errs.Error(),
+			Message: message,
+			//a stable code for tooling; the human-readable text stays in
+			//Message
+			Reason: denialReason(errs),
+		}
+		//each violation is also reported as its own cause with its field
+		//path, so clients do not have to parse the joined message
+		details := &metav1.StatusDetails{}
+		for _, e := range errs.Errors() {
+			cause := metav1.StatusCause{Message: e.Error()}
+			if ferr, ok := e.(*field.Error); ok {
+				cause.Type = metav1.CauseType(ferr.Type)
+				cause.Field = ferr.Field
+				cause.Message = ferr.ErrorBody()
+			}
+			details.Causes = append(details.Causes, cause)
 		}
+		result.Details = details
+		annotations["aro-admission-controller/decision"] = "deny"
+		annotations["aro-admission-controller/reason"] = errs.Error()
 	} else {
-		log.Print("No errors found, approved")
+		log.Printf("Request %s: allowing %s %s %s/%s", req.UID, req.Operation, req.Kind.Kind, req.Namespace, req.Name)
 	}
-	rev := &admissionv1beta1.AdmissionReview{
+	ac.recordDecision(req.Namespace, annotations["aro-admission-controller/decision"])
+	ac.notifySink(decisionEvent{
+		UID:       string(req.UID),
+		Decision:  annotations["aro-admission-controller/decision"],
+		Kind:      req.Kind.Kind,
+		Name:      req.Name,
+		Namespace: req.Namespace,
+		User:      req.UserInfo.Username,
+		Reason:    annotations["aro-admission-controller/reason"],
+	})
+	rev := &admissionReview{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: admissionv1beta1.SchemeGroupVersion.String(),
 			Kind:       "AdmissionReview",
 		},
-		Response: &admissionv1beta1.AdmissionResponse{
-			UID:     uid,
-			Allowed: result.Status == metav1.StatusSuccess,
-			Result:  result,
+		Response: &admissionResponse{
+			AdmissionResponse: &admissionv1beta1.AdmissionResponse{
+				UID:     req.UID,
+				Allowed: result.Status == metav1.StatusSuccess,
+				Result:  result,
+			},
+			AuditAnnotations: annotations,
 		},
 	}
+	//the request UID is echoed in a header as well, so proxy and access logs
+	//which never see the body can still be correlated with the API server
+	//audit log
+	w.Header().Set(requestUIDHeader, string(req.UID))
 	w.Header().Set("Content-Type", "application/json")
 	err := json.NewEncoder(w).Encode(rev)
 	if err != nil {
-		log.Fatalf("Error encoding json: %s", err)
+		//a failed write (e.g. the client disconnected) only loses this one
+		//response; it must not take down the process with its other
+		//in-flight requests
+		log.Printf("Request %s: error encoding response: %s", req.UID, err)
 	}
 }
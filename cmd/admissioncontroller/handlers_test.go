@@ -0,0 +1,4741 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghodss/yaml"
+	securityfake "github.com/openshift/origin/pkg/security/generated/internalclientset/fake"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/errors"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+	authorizationapi "k8s.io/kubernetes/pkg/apis/authorization"
+	"k8s.io/kubernetes/pkg/apis/core"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/fake"
+)
+
+func TestImageIsWhitelisted(t *testing.T) {
+	whitelistedImages := []*regexp.Regexp{
+		regexp.MustCompile("^registry.example.com/.*$"),
+		regexp.MustCompile("^whitelistedimage$"),
+	}
+	denylistedImages := []*regexp.Regexp{
+		regexp.MustCompile("^registry.example.com/bad/.*$"),
+	}
+
+	for _, test := range []struct {
+		name     string
+		image    string
+		expected bool
+	}{
+		{
+			name:     "image matching whitelist only, whitelisted",
+			image:    "registry.example.com/good/image:1.0",
+			expected: true,
+		},
+		{
+			name:     "image matching whitelist and denylist, not whitelisted",
+			image:    "registry.example.com/bad/image:1.0",
+			expected: false,
+		},
+		{
+			name:     "image matching neither list, not whitelisted",
+			image:    "someotherimage",
+			expected: false,
+		},
+		{
+			name:     "exact whitelist match, whitelisted",
+			image:    "whitelistedimage",
+			expected: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, _ := imageIsWhitelisted(test.image, whitelistedImages, denylistedImages)
+			if got != test.expected {
+				t.Errorf("imageIsWhitelisted(%s) = %v, expected %v", test.image, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestHandleValidate(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ac, err := NewAdmissionController(&config{}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod, err := json.Marshal(&corev1.Pod{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Pod",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Image: "regularimage",
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds, err := json.Marshal(&appsv1.DaemonSet{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "DaemonSet",
+			APIVersion: "apps/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Image: "regularimage",
+							SecurityContext: &corev1.SecurityContext{
+								Privileged: &[]bool{true}[0],
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name    string
+		request *admissionv1beta1.AdmissionRequest
+		allowed bool
+	}{
+		{
+			name: "pod dispatched to the whitelist validation, allow",
+			request: &admissionv1beta1.AdmissionRequest{
+				UID:      "uid",
+				Kind:     metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+				Resource: metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+				Object:   runtime.RawExtension{Raw: pod},
+			},
+			allowed: true,
+		},
+		{
+			name: "privileged daemonset dispatched to the whitelist validation, deny",
+			request: &admissionv1beta1.AdmissionRequest{
+				UID:      "uid",
+				Kind:     metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "DaemonSet"},
+				Resource: metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "daemonsets"},
+				Object:   runtime.RawExtension{Raw: ds},
+			},
+			allowed: false,
+		},
+		{
+			name: "protected SCC delete dispatched to the SCC validation, deny",
+			request: &admissionv1beta1.AdmissionRequest{
+				UID:       "uid",
+				Name:      "privileged",
+				Operation: admissionv1beta1.Delete,
+				Kind:      metav1.GroupVersionKind{Group: "security.openshift.io", Version: "v1", Kind: "SecurityContextConstraints"},
+				Resource:  metav1.GroupVersionResource{Group: "security.openshift.io", Version: "v1", Resource: "securitycontextconstraints"},
+			},
+			allowed: false,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := json.Marshal(&admissionv1beta1.AdmissionReview{Request: test.request})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := &http.Request{
+				Method: http.MethodPost,
+				Header: http.Header{"Content-Type": []string{"application/json"}},
+				Body:   ioutil.NopCloser(bytes.NewReader(req)),
+			}
+
+			w := newFakeResponseWriter()
+
+			ac.handleValidate(w, r)
+
+			if w.statusCode != 200 {
+				t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+			}
+
+			var rev *admissionv1beta1.AdmissionReview
+			err = json.NewDecoder(w).Decode(&rev)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if rev.Response.Allowed != test.allowed {
+				t.Errorf("got allowed %v, expected %v: %#v", rev.Response.Allowed, test.allowed, rev.Response.Result)
+			}
+		})
+	}
+}
+
+// TestHandleSCCNoRawDump ensures handleSCC does not dump the raw SCC object
+// (including its user and group lists) to the log at default verbosity.
+func TestHandleSCCNoRawDump(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	ac, err := NewAdmissionController(&config{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scc := `{
+		"metadata": {
+			"name": "notprotected"
+		},
+		"users": [
+			"system:serviceaccount:very-secret-namespace:very-secret-user"
+		],
+		"groups": [],
+		"typeMeta": {
+			"apiVersion": "security.openshift.io/v1",
+			"kind": "SecurityContextConstraints"
+		},
+		"seLinuxContext": {
+			"type": "MustRunAs"
+		},
+		"runAsUser": {
+			"type": "RunAsAny"
+		},
+		"supplementalGroups": {
+			"type": "RunAsAny"
+		},
+		"fSGroup": {
+			"type": "RunAsAny"
+		}
+	}
+	`
+	req, err := json.Marshal(&admissionv1beta1.AdmissionReview{
+		Request: &admissionv1beta1.AdmissionRequest{
+			UID:       "uid",
+			Operation: admissionv1beta1.Update,
+			Kind:      metav1.GroupVersionKind{Group: "security.openshift.io", Version: "v1", Kind: "SecurityContextConstraints"},
+			Resource:  metav1.GroupVersionResource{Group: "security.openshift.io", Version: "v1", Resource: "securitycontextconstraints"},
+			Object: runtime.RawExtension{
+				Raw: []byte(scc),
+			},
+		}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &http.Request{
+		Method: http.MethodPost,
+		Header: http.Header{"Content-Type": []string{"application/json"}},
+		Body:   ioutil.NopCloser(bytes.NewReader(req)),
+	}
+
+	w := newFakeResponseWriter()
+
+	ac.handleSCC(w, r)
+
+	if w.statusCode != 200 {
+		t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+	}
+	if strings.Contains(buf.String(), "very-secret-user") {
+		t.Errorf("raw SCC contents were dumped to the log: %s", buf.String())
+	}
+}
+
+func TestValidatePodOptionalChecks(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name                          string
+		rejectHostAliases             bool
+		requireTokenAutomountDisabled bool
+		pod                           *core.Pod
+		expectedError                 string
+	}{
+		{
+			name:              "host aliases rejected when enabled",
+			rejectHostAliases: true,
+			pod: &core.Pod{
+				Spec: core.PodSpec{
+					Containers: []core.Container{{Image: "regularimage"}},
+					HostAliases: []core.HostAlias{
+						{IP: "1.2.3.4", Hostnames: []string{"spoofed.example.com"}},
+					},
+				},
+			},
+			expectedError: "spec.hostAliases",
+		},
+		{
+			name: "host aliases allowed when disabled",
+			pod: &core.Pod{
+				Spec: core.PodSpec{
+					Containers: []core.Container{{Image: "regularimage"}},
+					HostAliases: []core.HostAlias{
+						{IP: "1.2.3.4", Hostnames: []string{"spoofed.example.com"}},
+					},
+				},
+			},
+		},
+		{
+			name:                          "unset automountServiceAccountToken rejected when required",
+			requireTokenAutomountDisabled: true,
+			pod: &core.Pod{
+				Spec: core.PodSpec{
+					Containers: []core.Container{{Image: "regularimage"}},
+				},
+			},
+			expectedError: "spec.automountServiceAccountToken",
+		},
+		{
+			name:                          "disabled automountServiceAccountToken passes when required",
+			requireTokenAutomountDisabled: true,
+			pod: &core.Pod{
+				Spec: core.PodSpec{
+					Containers:                   []core.Container{{Image: "regularimage"}},
+					AutomountServiceAccountToken: toBoolPtr(false),
+				},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ac, err := NewAdmissionController(&config{
+				RejectHostAliases:             test.rejectHostAliases,
+				RequireTokenAutomountDisabled: test.requireTokenAutomountDisabled,
+			}, client, restricted)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			errs, _, err := ac.validatePodAgainstSCC(context.Background(), test.pod, "default")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.expectedError == "" {
+				if len(errs) != 0 {
+					t.Errorf("got unexpected errors %v", errs)
+				}
+				return
+			}
+			found := false
+			for _, e := range errs {
+				if strings.Contains(e.Field, test.expectedError) {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("expected an error on %s, got %v", test.expectedError, errs)
+			}
+		})
+	}
+}
+
+func TestHandleSCCUpdateOldObject(t *testing.T) {
+	ac, err := NewAdmissionController(&config{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sccWithGroups := func(groups string) string {
+		return `{
+			"allowHostIPC": false,
+			"allowHostNetwork": false,
+			"allowHostPID": false,
+			"allowHostPorts": false,
+			"allowPrivilegeEscalation": true,
+			"allowPrivilegedContainer": false,
+			"fSGroup": {
+				"type": "RunAsAny"
+			},
+			"groups": [` + groups + `],
+			"metadata": {
+				"creationTimestamp": null,
+				"name": "anyuid",
+				"labels": {
+					"azure.openshift.io/owned-by-sync-pod": "true"
+				}
+			},
+			"priority": 10,
+			"readOnlyRootFilesystem": false,
+			"requiredDropCapabilities": [
+				"MKNOD"
+			],
+			"runAsUser": {
+				"type": "RunAsAny"
+			},
+			"seLinuxContext": {
+				"type": "MustRunAs"
+			},
+			"supplementalGroups": {
+				"type": "RunAsAny"
+			},
+			"typeMeta": {
+				"apiVersion": "security.openshift.io/v1",
+				"kind": "SecurityContextConstraints"
+			},
+			"users": null,
+			"volumes": [
+				"configMap",
+				"downwardAPI",
+				"emptyDir",
+				"persistentVolumeClaim",
+				"projected",
+				"secret"
+			]
+		}
+		`
+	}
+
+	for _, test := range []struct {
+		name     string
+		old      string
+		new      string
+		response *admissionv1beta1.AdmissionResponse
+	}{
+		{
+			name: "update removing a group added by a prior admin, forbid",
+			old:  sccWithGroups(`"system:cluster-admins", "myowngroup"`),
+			new:  sccWithGroups(`"system:cluster-admins"`),
+			response: &admissionv1beta1.AdmissionResponse{
+				UID:     "uid",
+				Allowed: false,
+				Result: &metav1.Status{
+					Status:  metav1.StatusFailure,
+					Message: "Removal of Group myowngroup from SCC is not allowed",
+					Reason:  reasonSCCProtected,
+				},
+			},
+		},
+		{
+			name: "update keeping prior additions and adding another group, allow",
+			old:  sccWithGroups(`"system:cluster-admins", "myowngroup"`),
+			new:  sccWithGroups(`"system:cluster-admins", "myowngroup", "anothergroup"`),
+			response: &admissionv1beta1.AdmissionResponse{
+				UID:     "uid",
+				Allowed: true,
+				Result: &metav1.Status{
+					Status: metav1.StatusSuccess,
+				},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := json.Marshal(&admissionv1beta1.AdmissionReview{
+				Request: &admissionv1beta1.AdmissionRequest{
+					UID:       "uid",
+					Operation: admissionv1beta1.Update,
+					Kind:      metav1.GroupVersionKind{Group: "security.openshift.io", Version: "v1", Kind: "SecurityContextConstraints"},
+					Resource:  metav1.GroupVersionResource{Group: "security.openshift.io", Version: "v1", Resource: "securitycontextconstraints"},
+					Object: runtime.RawExtension{
+						Raw: []byte(test.new),
+					},
+					OldObject: runtime.RawExtension{
+						Raw: []byte(test.old),
+					},
+				}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := &http.Request{
+				Method: http.MethodPost,
+				Header: http.Header{"Content-Type": []string{"application/json"}},
+				Body:   ioutil.NopCloser(bytes.NewReader(req)),
+			}
+
+			w := newFakeResponseWriter()
+
+			ac.handleSCC(w, r)
+
+			if w.statusCode != 200 {
+				t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+			}
+
+			var rev *admissionReview
+			err = json.NewDecoder(w).Decode(&rev)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(rev.Response, withDecisionAnnotations(test.response)) {
+				t.Errorf("got respose %#v, expected %#v", rev.Response, test.response)
+			}
+		})
+	}
+}
+
+func TestHandleOversizedRequestBody(t *testing.T) {
+	ac, err := NewAdmissionController(&config{MaxRequestBytes: 512}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte(`{"request": {"uid": "` + strings.Repeat("a", 1024) + `"}}`)
+	r := &http.Request{
+		Method: http.MethodPost,
+		Header: http.Header{"Content-Type": []string{"application/json"}},
+		Body:   ioutil.NopCloser(bytes.NewReader(body)),
+	}
+
+	w := newFakeResponseWriter()
+
+	ac.handleWhitelist(w, r)
+
+	if w.statusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("got status code %d, expected %d", w.statusCode, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestPodSpecIsWhitelistedContainerNames(t *testing.T) {
+	whitelistedImages := []*regexp.Regexp{
+		regexp.MustCompile("^whitelistedimage$"),
+	}
+
+	spec := &core.PodSpec{
+		Containers: []core.Container{
+			{Name: "good", Image: "whitelistedimage"},
+			{Name: "bad", Image: "otherimage"},
+		},
+		InitContainers: []core.Container{
+			{Name: "badinit", Image: "yetanotherimage"},
+		},
+	}
+
+	whitelisted, nonWhitelisted, _ := podSpecIsWhitelisted(spec, whitelistedImages, nil)
+	if whitelisted {
+		t.Error("pod with a non-whitelisted container was reported as whitelisted")
+	}
+	if !reflect.DeepEqual(nonWhitelisted, []string{"bad", "badinit"}) {
+		t.Errorf("got non-whitelisted containers %v, expected [bad badinit]", nonWhitelisted)
+	}
+}
+
+func TestVerifySCCSeccompAnnotation(t *testing.T) {
+	ac, err := NewAdmissionController(&config{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := ac.protectedSCCs["privileged"]
+
+	for _, test := range []struct {
+		name       string
+		annotation string
+		expectErr  bool
+	}{
+		{
+			name:       "annotation form equivalent to the field form, unchanged",
+			annotation: "*",
+		},
+		{
+			name:       "annotation form differing from the field form, modification",
+			annotation: "docker/default",
+			expectErr:  true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			scc := template.DeepCopy()
+			scc.SeccompProfiles = nil
+			scc.Labels = map[string]string{"azure.openshift.io/owned-by-sync-pod": "true"}
+			scc.Annotations = map[string]string{
+				"seccomp.security.alpha.kubernetes.io/allowedProfileNames": test.annotation,
+			}
+
+			errs := verifySCC(*scc, template)
+			if test.expectErr && errs == nil {
+				t.Error("expected a modification error, got none")
+			}
+			if !test.expectErr && errs != nil {
+				t.Errorf("got unexpected errors: %s", errs)
+			}
+		})
+	}
+}
+
+func TestValidatePodMissingNamespaceAnnotations(t *testing.T) {
+	//namespace without the openshift.io/sa.scc.* annotations the SCC provider
+	//needs to build its uid/mcs ranges
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "noannotations",
+		},
+	})
+
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ac, err := NewAdmissionController(&config{}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &core.Pod{
+		Spec: core.PodSpec{
+			Containers: []core.Container{{Image: "regularimage"}},
+		},
+	}
+
+	errs, _, err := ac.validatePodAgainstSCC(context.Background(), pod, "noannotations")
+	if err != nil {
+		t.Fatalf("expected a denial, got the internal error %s", err)
+	}
+	if len(errs) == 0 {
+		t.Fatal("expected a denial, got none")
+	}
+	if !strings.Contains(errs.ToAggregate().Error(), "cannot validate pod against SCC") {
+		t.Errorf("got unexpected denial message %s", errs.ToAggregate())
+	}
+}
+
+func TestHandleUpdateUnchangedSecurityFields(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ac, err := NewAdmissionController(&config{}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	marshalPod := func(annotations map[string]string, image string, pullPolicy corev1.PullPolicy) []byte {
+		pod, err := json.Marshal(&corev1.Pod{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "Pod",
+				APIVersion: "v1",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "default",
+				Annotations: annotations,
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					{
+						Name:            "mycontainer",
+						Image:           image,
+						ImagePullPolicy: pullPolicy,
+						SecurityContext: &corev1.SecurityContext{
+							Privileged: &[]bool{true}[0],
+						},
+					},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pod
+	}
+
+	for _, test := range []struct {
+		name    string
+		object  []byte
+		old     []byte
+		allowed bool
+	}{
+		{
+			name:    "annotation-only update on an admitted privileged pod, fast allow",
+			object:  marshalPod(map[string]string{"touched": "true"}, "privilegedimage", corev1.PullAlways),
+			old:     marshalPod(nil, "privilegedimage", corev1.PullAlways),
+			allowed: true,
+		},
+		{
+			name:    "image change on update, full validation denies",
+			object:  marshalPod(nil, "otherprivilegedimage", corev1.PullAlways),
+			old:     marshalPod(nil, "privilegedimage", corev1.PullAlways),
+			allowed: false,
+		},
+		{
+			name:    "pull policy change on update, full validation denies",
+			object:  marshalPod(nil, "privilegedimage", corev1.PullIfNotPresent),
+			old:     marshalPod(nil, "privilegedimage", corev1.PullAlways),
+			allowed: false,
+		},
+		{
+			name:    "seccomp-annotation-only update, full validation denies",
+			object:  marshalPod(map[string]string{"seccomp.security.alpha.kubernetes.io/pod": "unconfined"}, "privilegedimage", corev1.PullAlways),
+			old:     marshalPod(nil, "privilegedimage", corev1.PullAlways),
+			allowed: false,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := json.Marshal(&admissionv1beta1.AdmissionReview{Request: &admissionv1beta1.AdmissionRequest{
+				UID:       "uid",
+				Operation: admissionv1beta1.Update,
+				Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+				Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+				Object:    runtime.RawExtension{Raw: test.object},
+				OldObject: runtime.RawExtension{Raw: test.old},
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := &http.Request{
+				Method: http.MethodPost,
+				Header: http.Header{"Content-Type": []string{"application/json"}},
+				Body:   ioutil.NopCloser(bytes.NewReader(req)),
+			}
+
+			w := newFakeResponseWriter()
+
+			ac.handleWhitelist(w, r)
+
+			if w.statusCode != 200 {
+				t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+			}
+
+			var rev *admissionv1beta1.AdmissionReview
+			err = json.NewDecoder(w).Decode(&rev)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if rev.Response.Allowed != test.allowed {
+				t.Errorf("got allowed %v, expected %v: %#v", rev.Response.Allowed, test.allowed, rev.Response.Result)
+			}
+		})
+	}
+}
+
+// TestMatchedWhitelistPatternReported ensures an allowed whitelisted pod
+// reports the matching whitelist pattern for auditing.
+func TestMatchedWhitelistPatternReported(t *testing.T) {
+	matched, pattern := imageIsWhitelisted("whitelistedimage", []*regexp.Regexp{regexp.MustCompile("^whitelistedimage$")}, nil)
+	if !matched {
+		t.Fatal("expected the image to be whitelisted")
+	}
+	if pattern != "^whitelistedimage$" {
+		t.Errorf("got matched pattern %q, expected ^whitelistedimage$", pattern)
+	}
+
+	ac, err := NewAdmissionController(&config{
+		Whitelist: []string{"^whitelistedimage$"},
+	}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod, err := json.Marshal(&corev1.Pod{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Pod",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name:  "mycontainer",
+					Image: "whitelistedimage",
+					SecurityContext: &corev1.SecurityContext{
+						Privileged: &[]bool{true}[0],
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := json.Marshal(&admissionv1beta1.AdmissionReview{Request: &admissionv1beta1.AdmissionRequest{
+		UID:      "uid",
+		Kind:     metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Resource: metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+		Object:   runtime.RawExtension{Raw: pod},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &http.Request{
+		Method: http.MethodPost,
+		Header: http.Header{"Content-Type": []string{"application/json"}},
+		Body:   ioutil.NopCloser(bytes.NewReader(req)),
+	}
+
+	w := newFakeResponseWriter()
+
+	ac.handleWhitelist(w, r)
+
+	if w.statusCode != 200 {
+		t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+	}
+
+	var rev *admissionReview
+	err = json.NewDecoder(w).Decode(&rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !rev.Response.Allowed {
+		t.Fatalf("whitelisted pod was not allowed: %#v", rev.Response.Result)
+	}
+	if rev.Response.AuditAnnotations["aro-admission-controller/matched-rule"] != "^whitelistedimage$" {
+		t.Errorf("got audit annotations %v, expected the matched rule ^whitelistedimage$", rev.Response.AuditAnnotations)
+	}
+}
+
+// withDecisionAnnotations wraps the expected response with the audit
+// annotations sendResult adds to every response, plus any extra annotations
+// the case expects (e.g. the matched whitelist rule)
+func withDecisionAnnotations(resp *admissionv1beta1.AdmissionResponse, extra ...map[string]string) *admissionResponse {
+	wrapped := &admissionResponse{
+		AdmissionResponse: resp,
+		AuditAnnotations:  map[string]string{},
+	}
+	for _, m := range extra {
+		for k, v := range m {
+			wrapped.AuditAnnotations[k] = v
+		}
+	}
+	if resp.Allowed {
+		wrapped.AuditAnnotations["aro-admission-controller/decision"] = "allow"
+	} else {
+		wrapped.AuditAnnotations["aro-admission-controller/decision"] = "deny"
+		wrapped.AuditAnnotations["aro-admission-controller/reason"] = resp.Result.Message
+		//denials from a single plain error carry that error as their one
+		//cause; tests covering field errors or multiple violations spell
+		//their causes out explicitly
+		if resp.Result.Details == nil {
+			resp.Result.Details = &metav1.StatusDetails{
+				Causes: []metav1.StatusCause{{Message: resp.Result.Message}},
+			}
+		}
+	}
+	return wrapped
+}
+
+// TestSendResultAuditAnnotations ensures every response carries the decision
+// audit annotations, with the denial reason on denies and any annotations the
+// caller adds (e.g. the matched whitelist rule) preserved.
+func TestSendResultAuditAnnotations(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		errs        errors.Aggregate
+		annotations map[string]string
+		expected    map[string]string
+	}{
+		{
+			name:        "allowed whitelisted pod",
+			annotations: map[string]string{"aro-admission-controller/matched-rule": "^whitelistedimage$"},
+			expected: map[string]string{
+				"aro-admission-controller/decision":     "allow",
+				"aro-admission-controller/matched-rule": "^whitelistedimage$",
+			},
+		},
+		{
+			name: "denied privileged pod",
+			errs: errors.NewAggregate([]error{fmt.Errorf("Privileged containers are not allowed")}),
+			expected: map[string]string{
+				"aro-admission-controller/decision": "deny",
+				"aro-admission-controller/reason":   "Privileged containers are not allowed",
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ac, err := NewAdmissionController(&config{}, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := newFakeResponseWriter()
+			ac.sendResult(test.errs, w, &admissionv1beta1.AdmissionRequest{UID: "uid"}, test.annotations)
+
+			var rev *admissionReview
+			err = json.NewDecoder(w).Decode(&rev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(rev.Response.AuditAnnotations, test.expected) {
+				t.Errorf("got audit annotations %v, expected %v", rev.Response.AuditAnnotations, test.expected)
+			}
+		})
+	}
+}
+
+// TestValidatePodWindows ensures Windows pods are not evaluated against the
+// Linux restricted SCC (the nil client would fail provider creation) and that
+// the Windows-specific checks still deny Linux-only privileges.
+func TestValidatePodWindows(t *testing.T) {
+	ac, err := NewAdmissionController(&config{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name    string
+		pod     *core.Pod
+		allowed bool
+	}{
+		{
+			name: "plain windows pod, allow without Linux SCC evaluation",
+			pod: &core.Pod{
+				Spec: core.PodSpec{
+					NodeSelector: map[string]string{"kubernetes.io/os": "windows"},
+					Containers:   []core.Container{{Name: "win", Image: "windowsimage"}},
+				},
+			},
+			allowed: true,
+		},
+		{
+			name: "windows pod via beta os label, allow",
+			pod: &core.Pod{
+				Spec: core.PodSpec{
+					NodeSelector: map[string]string{"beta.kubernetes.io/os": "windows"},
+					Containers:   []core.Container{{Name: "win", Image: "windowsimage"}},
+				},
+			},
+			allowed: true,
+		},
+		{
+			name: "privileged windows pod, deny",
+			pod: &core.Pod{
+				Spec: core.PodSpec{
+					NodeSelector: map[string]string{"kubernetes.io/os": "windows"},
+					Containers: []core.Container{
+						{
+							Name:  "win",
+							Image: "windowsimage",
+							SecurityContext: &core.SecurityContext{
+								Privileged: &[]bool{true}[0],
+							},
+						},
+					},
+				},
+			},
+			allowed: false,
+		},
+		{
+			name: "windows pod with host network, deny",
+			pod: &core.Pod{
+				Spec: core.PodSpec{
+					NodeSelector:    map[string]string{"kubernetes.io/os": "windows"},
+					SecurityContext: &core.PodSecurityContext{HostNetwork: true},
+					Containers:      []core.Container{{Name: "win", Image: "windowsimage"}},
+				},
+			},
+			allowed: false,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			errs, _, err := ac.validatePodAgainstSCC(context.Background(), test.pod, "default")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (len(errs) == 0) != test.allowed {
+				t.Errorf("got errs %v, expected allowed %v", errs, test.allowed)
+			}
+		})
+	}
+}
+
+// TestHandleAppsGroupPayloads ensures apps/v1 DaemonSet and ReplicaSet
+// payloads unpack cleanly regardless of which internal type they decode to.
+func TestHandleAppsGroupPayloads(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ac, err := NewAdmissionController(&config{}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := corev1.PodTemplateSpec{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "mycontainer", Image: "regularimage"}},
+		},
+	}
+
+	ds, err := json.Marshal(&appsv1.DaemonSet{
+		TypeMeta:   metav1.TypeMeta{Kind: "DaemonSet", APIVersion: "apps/v1"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default"},
+		Spec:       appsv1.DaemonSetSpec{Template: template},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := json.Marshal(&appsv1.ReplicaSet{
+		TypeMeta:   metav1.TypeMeta{Kind: "ReplicaSet", APIVersion: "apps/v1"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default"},
+		Spec:       appsv1.ReplicaSetSpec{Template: template},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name    string
+		kind    string
+		payload []byte
+	}{
+		{name: "apps/v1 daemonset", kind: "DaemonSet", payload: ds},
+		{name: "apps/v1 replicaset", kind: "ReplicaSet", payload: rs},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := json.Marshal(&admissionv1beta1.AdmissionReview{Request: &admissionv1beta1.AdmissionRequest{
+				UID:      "uid",
+				Kind:     metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: test.kind},
+				Resource: metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: strings.ToLower(test.kind) + "s"},
+				Object:   runtime.RawExtension{Raw: test.payload},
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := &http.Request{
+				Method: http.MethodPost,
+				Header: http.Header{"Content-Type": []string{"application/json"}},
+				Body:   ioutil.NopCloser(bytes.NewReader(req)),
+			}
+
+			w := newFakeResponseWriter()
+
+			ac.handleWhitelist(w, r)
+
+			if w.statusCode != 200 {
+				t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+			}
+
+			var rev *admissionReview
+			err = json.NewDecoder(w).Decode(&rev)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !rev.Response.Allowed {
+				t.Errorf("expected allow, got %#v", rev.Response.Result)
+			}
+		})
+	}
+}
+
+// TestSendResultDenialMessageWrapping ensures the configured prefix and
+// suffix wrap denial messages, pointing customers at the whitelisting
+// process, while allowed responses are untouched.
+func TestSendResultDenialMessageWrapping(t *testing.T) {
+	ac, err := NewAdmissionController(&config{
+		DenialMessagePrefix: "[ARO]",
+		DenialMessageSuffix: "See https://aka.ms/aro-whitelisting to get an image whitelisted.",
+	}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := newFakeResponseWriter()
+	ac.sendResult(errors.NewAggregate([]error{fmt.Errorf("Privileged containers are not allowed")}), w, &admissionv1beta1.AdmissionRequest{UID: "uid"}, nil)
+
+	var rev *admissionReview
+	err = json.NewDecoder(w).Decode(&rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "[ARO] Privileged containers are not allowed See https://aka.ms/aro-whitelisting to get an image whitelisted."
+	if rev.Response.Result.Message != expected {
+		t.Errorf("got message %q, expected %q", rev.Response.Result.Message, expected)
+	}
+	if rev.Response.AuditAnnotations["aro-admission-controller/reason"] != "Privileged containers are not allowed" {
+		t.Errorf("got reason %q, expected the bare errors", rev.Response.AuditAnnotations["aro-admission-controller/reason"])
+	}
+
+	w = newFakeResponseWriter()
+	ac.sendResult(nil, w, &admissionv1beta1.AdmissionRequest{UID: "uid"}, nil)
+
+	rev = nil
+	err = json.NewDecoder(w).Decode(&rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rev.Response.Allowed || rev.Response.Result.Message != "" {
+		t.Errorf("allowed response unexpectedly carries a message: %#v", rev.Response.Result)
+	}
+}
+
+func TestImageIsFullyQualified(t *testing.T) {
+	for _, test := range []struct {
+		image    string
+		expected bool
+	}{
+		{image: "registry.example.com/good/image:1.0", expected: true},
+		{image: "registry.example.com/good/image@sha256:abcd", expected: true},
+		{image: "localhost/image:latest", expected: true},
+		{image: "registry.example.com:5000/image:1.0", expected: true},
+		{image: "registry.example.com/good/image", expected: false},
+		{image: "regularimage", expected: false},
+		{image: "library/image:1.0", expected: false},
+	} {
+		if got := imageIsFullyQualified(test.image); got != test.expected {
+			t.Errorf("imageIsFullyQualified(%s) = %v, expected %v", test.image, got, test.expected)
+		}
+	}
+}
+
+// TestRequireFullyQualifiedImages ensures strict mode denies whitelisted pods
+// with bare image references and stays out of the way when disabled.
+func TestRequireFullyQualifiedImages(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		strict  bool
+		image   string
+		allowed bool
+	}{
+		{name: "strict, fully qualified whitelisted image, allow", strict: true, image: "registry.example.com/image:1.0", allowed: true},
+		{name: "strict, bare whitelisted image, deny", strict: true, image: "whitelistedimage", allowed: false},
+		{name: "not strict, bare whitelisted image, allow", strict: false, image: "whitelistedimage", allowed: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ac, err := NewAdmissionController(&config{
+				Whitelist:                   []string{"^.*$"},
+				RequireFullyQualifiedImages: test.strict,
+			}, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pod, err := json.Marshal(&corev1.Pod{
+				TypeMeta:   metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default"},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "mycontainer", Image: test.image}},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req, err := json.Marshal(&admissionv1beta1.AdmissionReview{Request: &admissionv1beta1.AdmissionRequest{
+				UID:      "uid",
+				Kind:     metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+				Resource: metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+				Object:   runtime.RawExtension{Raw: pod},
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := &http.Request{
+				Method: http.MethodPost,
+				Header: http.Header{"Content-Type": []string{"application/json"}},
+				Body:   ioutil.NopCloser(bytes.NewReader(req)),
+			}
+
+			w := newFakeResponseWriter()
+
+			ac.handleWhitelist(w, r)
+
+			if w.statusCode != 200 {
+				t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+			}
+
+			var rev *admissionv1beta1.AdmissionReview
+			err = json.NewDecoder(w).Decode(&rev)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if rev.Response.Allowed != test.allowed {
+				t.Errorf("got allowed %v, expected %v: %#v", rev.Response.Allowed, test.allowed, rev.Response.Result)
+			}
+		})
+	}
+}
+
+// TestValidationDeadline ensures a slow API server trips the validation
+// deadline and the caller still gets a well-formed denial instead of a hang
+// or a 500.
+func TestValidationDeadline(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("get", "namespaces", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		time.Sleep(2 * time.Second)
+		return true, nil, fmt.Errorf("too late")
+	})
+
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ac, err := NewAdmissionController(&config{}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	pod, err := json.Marshal(&corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "mycontainer", Image: "regularimage"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := json.Marshal(&admissionv1beta1.AdmissionReview{Request: &admissionv1beta1.AdmissionRequest{
+		UID:      "uid",
+		Kind:     metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Resource: metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+		Object:   runtime.RawExtension{Raw: pod},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := (&http.Request{
+		Method: http.MethodPost,
+		Header: http.Header{"Content-Type": []string{"application/json"}},
+		Body:   ioutil.NopCloser(bytes.NewReader(req)),
+	}).WithContext(ctx)
+
+	w := newFakeResponseWriter()
+
+	done := make(chan struct{})
+	go func() {
+		ac.handleWhitelist(w, r)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("handler did not return after the deadline expired")
+	}
+
+	if w.statusCode != 200 {
+		t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+	}
+
+	var rev *admissionv1beta1.AdmissionReview
+	err = json.NewDecoder(w).Decode(&rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev.Response.Allowed {
+		t.Error("expected a denial when validation times out")
+	}
+	if !strings.Contains(rev.Response.Result.Message, "timed out") {
+		t.Errorf("got message %q, expected a timeout denial", rev.Response.Result.Message)
+	}
+}
+
+// TestVerifySCCPriorityChange asserts a Priority-only change on a protected
+// SCC is denied with its own prominent message.
+func TestVerifySCCPriorityChange(t *testing.T) {
+	ac, err := NewAdmissionController(&config{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template, found := ac.protectedSCCTemplate("restricted")
+	if !found {
+		t.Fatal("restricted template missing")
+	}
+
+	modified := template.DeepCopy()
+	modified.Labels = map[string]string{"azure.openshift.io/owned-by-sync-pod": "true"}
+	modified.Priority = toInt32Ptr(100)
+
+	errs := verifySCC(*modified, template)
+	if errs == nil {
+		t.Fatal("priority change was not denied")
+	}
+	expected := "Changing the Priority of a protected SCC is not allowed"
+	if errs.Error() != expected {
+		t.Errorf("got %q, expected %q", errs.Error(), expected)
+	}
+}
+
+// TestAllowedCapabilities ensures non-whitelisted pods may add the configured
+// capabilities, while any capability outside the set stays denied.
+func TestAllowedCapabilities(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ac, err := NewAdmissionController(&config{
+		AllowedCapabilities: []string{"NET_BIND_SERVICE"},
+	}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	podWithCapability := func(capability core.Capability) *core.Pod {
+		return &core.Pod{
+			Spec: core.PodSpec{
+				Containers: []core.Container{
+					{
+						Name:  "mycontainer",
+						Image: "regularimage",
+						SecurityContext: &core.SecurityContext{
+							Capabilities: &core.Capabilities{
+								Add: []core.Capability{capability},
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	errs, _, err := ac.validatePodAgainstSCC(context.Background(), podWithCapability("NET_BIND_SERVICE"), "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 0 {
+		t.Errorf("allowed capability was denied: %v", errs)
+	}
+
+	errs, _, err = ac.validatePodAgainstSCC(context.Background(), podWithCapability("SYS_ADMIN"), "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) == 0 {
+		t.Error("capability outside the configured set was not denied")
+	}
+}
+
+// TestHandleEphemeralContainers ensures kubectl debug containers arriving on
+// the pods/ephemeralcontainers subresource are validated like regular
+// containers.
+func TestHandleEphemeralContainers(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ac, err := NewAdmissionController(&config{}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name    string
+		pod     string
+		allowed bool
+	}{
+		{
+			name:    "plain debug container, allow",
+			pod:     `{"metadata": {"namespace": "default"}, "spec": {"ephemeralContainers": [{"name": "debugger", "image": "regularimage"}]}}`,
+			allowed: true,
+		},
+		{
+			name:    "privileged debug container, deny",
+			pod:     `{"metadata": {"namespace": "default"}, "spec": {"ephemeralContainers": [{"name": "debugger", "image": "regularimage", "securityContext": {"privileged": true}}]}}`,
+			allowed: false,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := json.Marshal(&admissionv1beta1.AdmissionReview{Request: &admissionv1beta1.AdmissionRequest{
+				UID:         "uid",
+				Kind:        metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+				Resource:    metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+				SubResource: "ephemeralcontainers",
+				Operation:   admissionv1beta1.Update,
+				Namespace:   "default",
+				Object:      runtime.RawExtension{Raw: []byte(test.pod)},
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := &http.Request{
+				Method: http.MethodPost,
+				Header: http.Header{"Content-Type": []string{"application/json"}},
+				Body:   ioutil.NopCloser(bytes.NewReader(req)),
+			}
+
+			w := newFakeResponseWriter()
+
+			ac.handleWhitelist(w, r)
+
+			if w.statusCode != 200 {
+				t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+			}
+
+			var rev *admissionv1beta1.AdmissionReview
+			err = json.NewDecoder(w).Decode(&rev)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if rev.Response.Allowed != test.allowed {
+				t.Errorf("got allowed %v, expected %v: %#v", rev.Response.Allowed, test.allowed, rev.Response.Result)
+			}
+		})
+	}
+}
+
+// TestDecodeFailureModes covers the strict (HTTP 400) and lenient (denial
+// with a clear message) handling of undecodable objects.
+func TestDecodeFailureModes(t *testing.T) {
+	for _, test := range []struct {
+		name           string
+		lenient        bool
+		expectedStatus int
+	}{
+		{name: "strict mode, 400", lenient: false, expectedStatus: 400},
+		{name: "lenient mode, denial", lenient: true, expectedStatus: 200},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ac, err := NewAdmissionController(&config{LenientDecode: test.lenient}, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req, err := json.Marshal(&admissionv1beta1.AdmissionReview{Request: &admissionv1beta1.AdmissionRequest{
+				UID:      "uid",
+				Kind:     metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+				Resource: metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+				//valid JSON whose spec does not decode into a Pod
+				Object: runtime.RawExtension{Raw: []byte(`{"apiVersion": "v1", "kind": "Pod", "spec": {"containers": "notalist"}}`)},
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := &http.Request{
+				Method: http.MethodPost,
+				Header: http.Header{"Content-Type": []string{"application/json"}},
+				Body:   ioutil.NopCloser(bytes.NewReader(req)),
+			}
+
+			w := newFakeResponseWriter()
+
+			ac.handleWhitelist(w, r)
+
+			if w.statusCode != test.expectedStatus {
+				t.Fatalf("got status code %d, expected %d", w.statusCode, test.expectedStatus)
+			}
+			if test.lenient {
+				var rev *admissionv1beta1.AdmissionReview
+				err = json.NewDecoder(w).Decode(&rev)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if rev.Response.Allowed {
+					t.Error("undecodable object was allowed in lenient mode")
+				}
+				if !strings.Contains(rev.Response.Result.Message, "could not decode the object under review") {
+					t.Errorf("got message %q, expected a decode failure denial", rev.Response.Result.Message)
+				}
+			}
+		})
+	}
+}
+
+// TestUnregisteredKindDecode verifies a review for a kind the scheme does
+// not know is answered with a clear message naming the kind instead of the
+// scheme's internal error text.
+func TestUnregisteredKindDecode(t *testing.T) {
+	ac, err := NewAdmissionController(&config{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := json.Marshal(&admissionv1beta1.AdmissionReview{Request: &admissionv1beta1.AdmissionRequest{
+		UID:      "uid",
+		Kind:     metav1.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"},
+		Resource: metav1.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"},
+		Object:   runtime.RawExtension{Raw: []byte(`{"apiVersion": "example.com/v1", "kind": "Widget", "metadata": {"name": "w", "namespace": "default"}}`)},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &http.Request{
+		Method: http.MethodPost,
+		Header: http.Header{"Content-Type": []string{"application/json"}},
+		Body:   ioutil.NopCloser(bytes.NewReader(req)),
+	}
+
+	w := newFakeResponseWriter()
+
+	ac.handleWhitelist(w, r)
+
+	if w.statusCode != 400 {
+		t.Fatalf("got status code %d, expected 400: %s", w.statusCode, w.Buffer.String())
+	}
+	if !strings.Contains(w.Buffer.String(), `no decoder is registered for example.com/v1, Kind=Widget`) {
+		t.Errorf("got %q, expected a message naming the unregistered kind", w.Buffer.String())
+	}
+}
+
+// TestGzipEncodedReview verifies a gzip-compressed AdmissionReview body is
+// decompressed and reviewed like a plain one.
+func TestGzipEncodedReview(t *testing.T) {
+	ac, err := NewAdmissionController(&config{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := json.Marshal(&admissionv1beta1.AdmissionReview{Request: &admissionv1beta1.AdmissionRequest{
+		UID:      "uid",
+		Kind:     metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Resource: metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+		Object:   runtime.RawExtension{Raw: []byte(`{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "pod", "namespace": "default", "nodeSelector": {}}, "spec": {"nodeSelector": {"node-role.kubernetes.io/master": "true"}, "containers": [{"name": "c", "image": "image"}]}}`)},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &http.Request{
+		Method: http.MethodPost,
+		Header: http.Header{
+			"Content-Type":     []string{"application/json"},
+			"Content-Encoding": []string{"gzip"},
+		},
+		Body: ioutil.NopCloser(bytes.NewReader(compressed.Bytes())),
+	}
+
+	w := newFakeResponseWriter()
+
+	ac.handleWhitelist(w, r)
+
+	if w.statusCode != 200 {
+		t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+	}
+	var rev *admissionv1beta1.AdmissionReview
+	err = json.NewDecoder(w).Decode(&rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rev.Response.Allowed {
+		t.Errorf("pod was not allowed: %#v", rev.Response.Result)
+	}
+}
+
+// TestHandleCronJobEmptyJobTemplate ensures a CronJob without a jobTemplate
+// is rejected cleanly instead of being allowed with nothing to validate.
+func TestHandleCronJobEmptyJobTemplate(t *testing.T) {
+	ac, err := NewAdmissionController(&config{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := json.Marshal(&admissionv1beta1.AdmissionReview{Request: &admissionv1beta1.AdmissionRequest{
+		UID:      "uid",
+		Kind:     metav1.GroupVersionKind{Group: "batch", Version: "v1beta1", Kind: "CronJob"},
+		Resource: metav1.GroupVersionResource{Group: "batch", Version: "v1beta1", Resource: "cronjobs"},
+		Object:   runtime.RawExtension{Raw: []byte(`{"apiVersion": "batch/v1beta1", "kind": "CronJob", "metadata": {"namespace": "default"}, "spec": {"schedule": "* * * * *"}}`)},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &http.Request{
+		Method: http.MethodPost,
+		Header: http.Header{"Content-Type": []string{"application/json"}},
+		Body:   ioutil.NopCloser(bytes.NewReader(req)),
+	}
+
+	w := newFakeResponseWriter()
+
+	ac.handleWhitelist(w, r)
+
+	if w.statusCode != 400 {
+		t.Fatalf("got status code %d, expected 400: %s", w.statusCode, w.Buffer.String())
+	}
+	if !strings.Contains(w.Buffer.String(), "no pod template") {
+		t.Errorf("got %q, expected a no pod template error", w.Buffer.String())
+	}
+}
+
+// TestRequestUIDCorrelation verifies the request UID shows up in the response
+// header and in the log lines written while handling the request.
+func TestRequestUIDCorrelation(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAdmissionController(&config{}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod, err := json.Marshal(&corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "c", Image: "image"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := json.Marshal(&admissionv1beta1.AdmissionReview{
+		Request: &admissionv1beta1.AdmissionRequest{
+			UID:       "correlation-uid",
+			Operation: admissionv1beta1.Create,
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Object:    runtime.RawExtension{Raw: pod},
+		}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &http.Request{
+		Method: http.MethodPost,
+		Header: http.Header{"Content-Type": []string{"application/json"}},
+		Body:   ioutil.NopCloser(bytes.NewReader(req)),
+	}
+	w := newFakeResponseWriter()
+
+	ac.handleWhitelist(w, r)
+
+	if w.statusCode != 200 {
+		t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+	}
+	if got := w.Header().Get(requestUIDHeader); got != "correlation-uid" {
+		t.Errorf("got %s header %q, expected the request UID", requestUIDHeader, got)
+	}
+	if !strings.Contains(buf.String(), "Request correlation-uid") {
+		t.Errorf("log lines do not carry the request UID: %s", buf.String())
+	}
+}
+
+// TestForbiddenCombinations verifies a configured privilege combination is
+// denied even on a whitelisted pod, while the individual flags stay allowed.
+func TestForbiddenCombinations(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewAdmissionController(&config{
+		ForbiddenCombinations: [][]string{{"privileged", "hostpid"}},
+	}, client, restricted); err == nil {
+		t.Error("unknown combination flag was not rejected")
+	}
+
+	ac, err := NewAdmissionController(&config{
+		Whitelist:             []string{"^whitelistedimage$"},
+		ForbiddenCombinations: [][]string{{"privileged", "hostPID", "hostNetwork"}},
+	}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &core.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+		Spec: core.PodSpec{
+			SecurityContext: &core.PodSecurityContext{
+				HostPID:     true,
+				HostNetwork: true,
+			},
+			Containers: []core.Container{
+				{
+					Name:  "c",
+					Image: "whitelistedimage",
+					SecurityContext: &core.SecurityContext{
+						Privileged: toBoolPtr(true),
+					},
+				},
+			},
+		},
+	}
+	errs, _, err := ac.validatePodAgainstSCC(context.Background(), pod, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "even for whitelisted pods") {
+		t.Errorf("forbidden combination on a whitelisted pod was not denied: %v", errs)
+	}
+
+	//the flags individually remain covered by the whitelist
+	pod.Spec.SecurityContext.HostNetwork = false
+	errs, matchedPatterns, err := ac.validatePodAgainstSCC(context.Background(), pod, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 0 {
+		t.Errorf("whitelisted pod without the full combination was denied: %v", errs)
+	}
+	if len(matchedPatterns) != 1 {
+		t.Errorf("got matched patterns %v", matchedPatterns)
+	}
+}
+
+// TestSendResultCauses verifies each violation is reported as a separate
+// status cause carrying its field path.
+func TestSendResultCauses(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAdmissionController(&config{}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//two containers violating the restricted SCC independently
+	pod := &core.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+		Spec: core.PodSpec{
+			Containers: []core.Container{
+				{
+					Name:  "first",
+					Image: "image1",
+					SecurityContext: &core.SecurityContext{
+						Privileged: toBoolPtr(true),
+					},
+				},
+				{
+					Name:  "second",
+					Image: "image2",
+					SecurityContext: &core.SecurityContext{
+						Privileged: toBoolPtr(true),
+					},
+				},
+			},
+		},
+	}
+	errs, _, err := ac.validatePodAgainstSCC(context.Background(), pod, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := newFakeResponseWriter()
+	ac.sendResult(errs.ToAggregate(), w, &admissionv1beta1.AdmissionRequest{UID: "uid"}, nil)
+
+	var rev *admissionv1beta1.AdmissionReview
+	err = json.NewDecoder(w).Decode(&rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev.Response.Allowed {
+		t.Fatal("pod with two privileged containers was allowed")
+	}
+	if rev.Response.Result.Details == nil {
+		t.Fatal("denial carries no status details")
+	}
+	var fields []string
+	for _, cause := range rev.Response.Result.Details.Causes {
+		fields = append(fields, cause.Field)
+	}
+	for _, expected := range []string{"containers[0].securityContext.privileged", "containers[1].securityContext.privileged"} {
+		found := false
+		for _, f := range fields {
+			if strings.Contains(f, expected) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("no cause names %s, got fields %v", expected, fields)
+		}
+	}
+}
+
+// TestServiceAccountExemptions verifies requests from a matching service
+// account bypass validation while other users still go through it.
+func TestServiceAccountExemptions(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAdmissionController(&config{
+		ServiceAccountExemptions: []string{`^system:serviceaccount:addons:trusted-.*$`},
+	}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod, err := json.Marshal(&corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name:  "c",
+					Image: "privilegedimage",
+					SecurityContext: &corev1.SecurityContext{
+						Privileged: toBoolPtr(true),
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name     string
+		username string
+		allowed  bool
+	}{
+		{
+			name:     "matching service account, allow",
+			username: "system:serviceaccount:addons:trusted-agent",
+			allowed:  true,
+		},
+		{
+			name:     "non-matching service account, deny",
+			username: "system:serviceaccount:customer:untrusted-agent",
+			allowed:  false,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := json.Marshal(&admissionv1beta1.AdmissionReview{
+				Request: &admissionv1beta1.AdmissionRequest{
+					UID:       "uid",
+					Operation: admissionv1beta1.Create,
+					Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+					UserInfo:  authenticationv1.UserInfo{Username: test.username},
+					Object:    runtime.RawExtension{Raw: pod},
+				}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := &http.Request{
+				Method: http.MethodPost,
+				Header: http.Header{"Content-Type": []string{"application/json"}},
+				Body:   ioutil.NopCloser(bytes.NewReader(req)),
+			}
+			w := newFakeResponseWriter()
+
+			ac.handleWhitelist(w, r)
+
+			if w.statusCode != 200 {
+				t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+			}
+			var rev *admissionReview
+			err = json.NewDecoder(w).Decode(&rev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rev.Response.Allowed != test.allowed {
+				t.Errorf("got allowed %v, expected %v: %v", rev.Response.Allowed, test.allowed, rev.Response.Result)
+			}
+			if test.allowed && rev.Response.AuditAnnotations["aro-admission-controller/exempted-user"] != test.username {
+				t.Errorf("exempted user not recorded in audit annotations: %v", rev.Response.AuditAnnotations)
+			}
+		})
+	}
+}
+
+// TestSkipLabel verifies the opt-out label is honored only when the requester
+// is allowed to use the privileged SCC.
+func TestSkipLabel(t *testing.T) {
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod, err := json.Marshal(&corev1.Pod{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pod",
+			Namespace: "default",
+			Labels:    map[string]string{"aro-admission-controller/skip": "true"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name:  "c",
+					Image: "privilegedimage",
+					SecurityContext: &corev1.SecurityContext{
+						Privileged: toBoolPtr(true),
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name       string
+		authorized bool
+		allowed    bool
+	}{
+		{
+			name:       "authorized requester, skip honored",
+			authorized: true,
+			allowed:    true,
+		},
+		{
+			name:       "unauthorized requester, label ignored",
+			authorized: false,
+			allowed:    false,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(&core.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "default",
+					Annotations: map[string]string{
+						"openshift.io/sa.scc.uid-range": "1000/10",
+						"openshift.io/sa.scc.mcs":       "mcs",
+					},
+				},
+			})
+			authorized := test.authorized
+			client.PrependReactor("create", "subjectaccessreviews", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				return true, &authorizationapi.SubjectAccessReview{
+					Status: authorizationapi.SubjectAccessReviewStatus{Allowed: authorized},
+				}, nil
+			})
+			ac, err := NewAdmissionController(&config{HonorSkipLabel: true}, client, restricted)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req, err := json.Marshal(&admissionv1beta1.AdmissionReview{
+				Request: &admissionv1beta1.AdmissionRequest{
+					UID:       "uid",
+					Operation: admissionv1beta1.Create,
+					Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+					UserInfo:  authenticationv1.UserInfo{Username: "user"},
+					Object:    runtime.RawExtension{Raw: pod},
+				}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := &http.Request{
+				Method: http.MethodPost,
+				Header: http.Header{"Content-Type": []string{"application/json"}},
+				Body:   ioutil.NopCloser(bytes.NewReader(req)),
+			}
+			w := newFakeResponseWriter()
+
+			ac.handleWhitelist(w, r)
+
+			if w.statusCode != 200 {
+				t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+			}
+			var rev *admissionReview
+			err = json.NewDecoder(w).Decode(&rev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rev.Response.Allowed != test.allowed {
+				t.Errorf("got allowed %v, expected %v: %v", rev.Response.Allowed, test.allowed, rev.Response.Result)
+			}
+			if test.allowed && rev.Response.AuditAnnotations["aro-admission-controller/skipped"] != "true" {
+				t.Errorf("skip was not recorded in audit annotations: %v", rev.Response.AuditAnnotations)
+			}
+		})
+	}
+}
+
+// TestHandleSuspendedIndexedJob verifies newer batch/v1 Job fields (suspend,
+// completionMode) decode without error and do not stop the pod template from
+// being validated.
+func TestHandleSuspendedIndexedJob(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAdmissionController(&config{}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the vendored batch types predate suspend and completionMode, so the
+	//payload is built as raw JSON the way the API server would send it
+	job := `{
+		"apiVersion": "batch/v1",
+		"kind": "Job",
+		"metadata": {
+			"name": "indexed",
+			"namespace": "default"
+		},
+		"spec": {
+			"suspend": true,
+			"completionMode": "Indexed",
+			"completions": 3,
+			"parallelism": 3,
+			"template": {
+				"spec": {
+					"containers": [
+						{
+							"name": "worker",
+							"image": "workerimage",
+							"securityContext": {
+								"privileged": true
+							}
+						}
+					]
+				}
+			}
+		}
+	}`
+	req, err := json.Marshal(&admissionv1beta1.AdmissionReview{
+		Request: &admissionv1beta1.AdmissionRequest{
+			UID:       "uid",
+			Operation: admissionv1beta1.Create,
+			Kind:      metav1.GroupVersionKind{Group: "batch", Version: "v1", Kind: "Job"},
+			Object:    runtime.RawExtension{Raw: []byte(job)},
+		}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &http.Request{
+		Method: http.MethodPost,
+		Header: http.Header{"Content-Type": []string{"application/json"}},
+		Body:   ioutil.NopCloser(bytes.NewReader(req)),
+	}
+	w := newFakeResponseWriter()
+
+	ac.handleWhitelist(w, r)
+
+	if w.statusCode != 200 {
+		t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+	}
+	var rev *admissionv1beta1.AdmissionReview
+	err = json.NewDecoder(w).Decode(&rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev.Response.Allowed {
+		t.Error("privileged template of a suspended indexed Job was not validated")
+	}
+	if !strings.Contains(rev.Response.Result.Message, "Privileged containers are not allowed") {
+		t.Errorf("got message %q", rev.Response.Result.Message)
+	}
+}
+
+// TestHandleBetaAppsVersions verifies apps/v1beta1 and v1beta2 payloads decode
+// into the internal types and their pod templates are validated.
+func TestHandleBetaAppsVersions(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAdmissionController(&config{}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statefulSet := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "ss", Namespace: "default"},
+		Spec: appsv1.StatefulSetSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "c",
+							Image: "image",
+							SecurityContext: &corev1.SecurityContext{
+								Privileged: toBoolPtr(true),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	for _, version := range []string{"v1beta1", "v1beta2"} {
+		t.Run(version, func(t *testing.T) {
+			statefulSet.TypeMeta = metav1.TypeMeta{APIVersion: "apps/" + version, Kind: "StatefulSet"}
+			raw, err := json.Marshal(statefulSet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req, err := json.Marshal(&admissionv1beta1.AdmissionReview{
+				Request: &admissionv1beta1.AdmissionRequest{
+					UID:       "uid",
+					Operation: admissionv1beta1.Create,
+					Kind:      metav1.GroupVersionKind{Group: "apps", Version: version, Kind: "StatefulSet"},
+					Object:    runtime.RawExtension{Raw: raw},
+				}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := &http.Request{
+				Method: http.MethodPost,
+				Header: http.Header{"Content-Type": []string{"application/json"}},
+				Body:   ioutil.NopCloser(bytes.NewReader(req)),
+			}
+			w := newFakeResponseWriter()
+
+			ac.handleWhitelist(w, r)
+
+			if w.statusCode != 200 {
+				t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+			}
+			var rev *admissionv1beta1.AdmissionReview
+			err = json.NewDecoder(w).Decode(&rev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rev.Response.Allowed {
+				t.Errorf("privileged apps/%s StatefulSet was allowed", version)
+			}
+		})
+	}
+}
+
+// TestDecisionCacheInvalidatedOnReload verifies cached decisions do not
+// outlive a config reload which changes the whitelist.
+func TestDecisionCacheInvalidatedOnReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "decision-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configPath := dir + "/aro-admission-controller.yaml"
+	err = ioutil.WriteFile(configPath, []byte("whitelist:\n- ^cachedimage$\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAdmissionController(&config{
+		Whitelist:               []string{"^cachedimage$"},
+		DecisionCacheTTLSeconds: 60,
+	}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &core.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+		Spec: core.PodSpec{
+			Containers: []core.Container{
+				{
+					Name:  "c",
+					Image: "cachedimage",
+					SecurityContext: &core.SecurityContext{
+						Privileged: toBoolPtr(true),
+					},
+				},
+			},
+		},
+	}
+
+	w := newFakeResponseWriter()
+	ac.checkPodSpec(context.Background(), pod.Spec, pod.ObjectMeta, "default", w, &admissionv1beta1.AdmissionRequest{UID: "uid"})
+	var rev *admissionv1beta1.AdmissionReview
+	if err := json.NewDecoder(w).Decode(&rev); err != nil {
+		t.Fatal(err)
+	}
+	if !rev.Response.Allowed {
+		t.Fatalf("whitelisted pod was denied: %v", rev.Response.Result)
+	}
+
+	//drop the image from the whitelist and reload; the cached allow must not
+	//survive
+	err = ioutil.WriteFile(configPath, []byte("whitelist:\n- ^otherimage$\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ac.reloadConfig(configPath); err != nil {
+		t.Fatal(err)
+	}
+
+	w = newFakeResponseWriter()
+	rev = nil
+	ac.checkPodSpec(context.Background(), pod.Spec, pod.ObjectMeta, "default", w, &admissionv1beta1.AdmissionRequest{UID: "uid"})
+	if err := json.NewDecoder(w).Decode(&rev); err != nil {
+		t.Fatal(err)
+	}
+	if rev.Response.Allowed {
+		t.Error("cached allow survived the config reload")
+	}
+}
+
+// BenchmarkCheckPodSpec shows the effect of the decision cache on repeated
+// identical requests.
+func BenchmarkCheckPodSpec(b *testing.B) {
+	for _, bench := range []struct {
+		name string
+		ttl  int
+	}{
+		{name: "uncached", ttl: 0},
+		{name: "cached", ttl: 60},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			client := fake.NewSimpleClientset(&core.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "default",
+					Annotations: map[string]string{
+						"openshift.io/sa.scc.uid-range": "1000/10",
+						"openshift.io/sa.scc.mcs":       "mcs",
+					},
+				},
+			})
+			restricted, err := getRestrictedSCC()
+			if err != nil {
+				b.Fatal(err)
+			}
+			ac, err := NewAdmissionController(&config{DecisionCacheTTLSeconds: bench.ttl}, client, restricted)
+			if err != nil {
+				b.Fatal(err)
+			}
+			log.SetOutput(ioutil.Discard)
+			defer log.SetOutput(os.Stderr)
+
+			spec := core.PodSpec{
+				Containers: []core.Container{{Name: "c", Image: "image"}},
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				ac.checkPodSpec(context.Background(), spec, metav1.ObjectMeta{}, "default", newFakeResponseWriter(), &admissionv1beta1.AdmissionRequest{UID: "uid"})
+			}
+		})
+	}
+}
+
+// TestValidateSELinuxOptions confirms the MustRunAs SELinux strategy of the
+// restricted SCC is enforced for non-whitelisted pods: arbitrary type/level
+// requests are denied, the namespace MCS level is accepted.
+func TestValidateSELinuxOptions(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAdmissionController(&config{}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name           string
+		seLinuxOptions *core.SELinuxOptions
+		allowed        bool
+	}{
+		{
+			name: "custom type and level, deny",
+			seLinuxOptions: &core.SELinuxOptions{
+				Type:  "spc_t",
+				Level: "s0:c123,c456",
+			},
+			allowed: false,
+		},
+		{
+			name: "namespace MCS level, allow",
+			seLinuxOptions: &core.SELinuxOptions{
+				Level: "mcs",
+			},
+			allowed: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			pod := &core.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+				Spec: core.PodSpec{
+					SecurityContext: &core.PodSecurityContext{
+						SELinuxOptions: test.seLinuxOptions,
+					},
+					Containers: []core.Container{
+						{Name: "c", Image: "image"},
+					},
+				},
+			}
+			errs, _, err := ac.validatePodAgainstSCC(context.Background(), pod, "default")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.allowed && len(errs) != 0 {
+				t.Errorf("pod with the namespace MCS level was denied: %v", errs)
+			}
+			if !test.allowed && len(errs) == 0 {
+				t.Error("pod requesting a custom SELinux type and level was allowed")
+			}
+		})
+	}
+}
+
+// TestHandleYAMLAdmissionReview verifies a YAML-encoded AdmissionReview is
+// accepted when posted as application/yaml and answered in JSON.
+func TestHandleYAMLAdmissionReview(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAdmissionController(&config{}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod, err := json.Marshal(&corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name:  "c",
+					Image: "image",
+					SecurityContext: &corev1.SecurityContext{
+						Privileged: toBoolPtr(true),
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := json.Marshal(&admissionv1beta1.AdmissionReview{
+		Request: &admissionv1beta1.AdmissionRequest{
+			UID:       "uid",
+			Operation: admissionv1beta1.Create,
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Object:    runtime.RawExtension{Raw: pod},
+		}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	yamlReq, err := yaml.JSONToYAML(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &http.Request{
+		Method: http.MethodPost,
+		Header: http.Header{"Content-Type": []string{"application/yaml"}},
+		Body:   ioutil.NopCloser(bytes.NewReader(yamlReq)),
+	}
+	w := newFakeResponseWriter()
+
+	ac.handleWhitelist(w, r)
+
+	if w.statusCode != 200 {
+		t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+	}
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("got response Content-Type %q", w.Header().Get("Content-Type"))
+	}
+	var rev *admissionv1beta1.AdmissionReview
+	err = json.NewDecoder(w).Decode(&rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev.Response.UID != "uid" {
+		t.Errorf("got response UID %q", rev.Response.UID)
+	}
+	if rev.Response.Allowed {
+		t.Error("privileged pod submitted as YAML was allowed")
+	}
+
+	//other content types are still rejected
+	r = &http.Request{
+		Method: http.MethodPost,
+		Header: http.Header{"Content-Type": []string{"text/xml"}},
+		Body:   ioutil.NopCloser(bytes.NewReader(yamlReq)),
+	}
+	w = newFakeResponseWriter()
+	ac.handleWhitelist(w, r)
+	if w.statusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("got status code %d for text/xml, expected 415", w.statusCode)
+	}
+}
+
+func TestCanonicalImageReference(t *testing.T) {
+	for _, test := range []struct {
+		image    string
+		expected string
+	}{
+		{image: "app", expected: "docker.io/library/app:latest"},
+		{image: "app:1.0", expected: "docker.io/library/app:1.0"},
+		{image: "registry/app", expected: "docker.io/registry/app:latest"},
+		{image: "registry/app:1.0", expected: "docker.io/registry/app:1.0"},
+		{image: "index.docker.io/registry/app:1.0", expected: "docker.io/registry/app:1.0"},
+		{image: "registry.example.com/app", expected: "registry.example.com/app:latest"},
+		{image: "registry.example.com/app:1.0", expected: "registry.example.com/app:1.0"},
+		{image: "registry.example.com:5000/app:1.0", expected: "registry.example.com:5000/app:1.0"},
+		{image: "localhost/app", expected: "localhost/app:latest"},
+		{image: "registry.example.com/app@sha256:abcd", expected: "registry.example.com/app@sha256:abcd"},
+		{image: "", expected: ""},
+	} {
+		if got := canonicalImageReference(test.image); got != test.expected {
+			t.Errorf("canonicalImageReference(%q) = %q, expected %q", test.image, got, test.expected)
+		}
+	}
+}
+
+// TestImageIsWhitelistedCanonicalForms verifies equivalent spellings of the
+// same image are matched against whitelist and denylist entries authored in
+// the canonical form.
+func TestImageIsWhitelistedCanonicalForms(t *testing.T) {
+	whitelistedImages := []*regexp.Regexp{
+		regexp.MustCompile(`^docker.io/registry/app:1.0$`),
+	}
+	denylistedImages := []*regexp.Regexp{
+		regexp.MustCompile(`^docker.io/library/badimage:latest$`),
+	}
+
+	for _, test := range []struct {
+		image    string
+		expected bool
+	}{
+		//equivalent spellings of the whitelisted image
+		{image: "registry/app:1.0", expected: true},
+		{image: "docker.io/registry/app:1.0", expected: true},
+		{image: "index.docker.io/registry/app:1.0", expected: true},
+		//a different tag of the same repository stays non-whitelisted
+		{image: "registry/app:2.0", expected: false},
+		//equivalent spellings cannot dodge the denylist
+		{image: "badimage", expected: false},
+		{image: "docker.io/library/badimage", expected: false},
+	} {
+		got, _ := imageIsWhitelisted(test.image, whitelistedImages, denylistedImages)
+		if got != test.expected {
+			t.Errorf("imageIsWhitelisted(%s) = %v, expected %v", test.image, got, test.expected)
+		}
+	}
+}
+
+// TestHandleSCCConnect verifies a Connect operation is answered explicitly
+// instead of falling through to the decode path.
+func TestHandleSCCConnect(t *testing.T) {
+	ac, err := NewAdmissionController(&config{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := json.Marshal(&admissionv1beta1.AdmissionReview{
+		Request: &admissionv1beta1.AdmissionRequest{
+			UID:       "uid",
+			Operation: admissionv1beta1.Connect,
+			Kind:      metav1.GroupVersionKind{Group: "security.openshift.io", Version: "v1", Kind: "SecurityContextConstraints"},
+			Name:      "privileged",
+		}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &http.Request{
+		Method: http.MethodPost,
+		Header: http.Header{"Content-Type": []string{"application/json"}},
+		Body:   ioutil.NopCloser(bytes.NewReader(req)),
+	}
+	w := newFakeResponseWriter()
+
+	ac.handleSCC(w, r)
+
+	if w.statusCode != 200 {
+		t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+	}
+	var rev *admissionv1beta1.AdmissionReview
+	err = json.NewDecoder(w).Decode(&rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rev.Response.Allowed {
+		t.Errorf("Connect on an SCC was not allowed: %v", rev.Response.Result)
+	}
+}
+
+// TestRejectPodOverhead verifies spec.overhead on a non-whitelisted pod is
+// denied only when the option is enabled.
+func TestRejectPodOverhead(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the typed vendored Pod predates overhead, so the payload is raw JSON
+	pod := `{
+		"apiVersion": "v1",
+		"kind": "Pod",
+		"metadata": {
+			"name": "pod",
+			"namespace": "default"
+		},
+		"spec": {
+			"overhead": {
+				"cpu": "250m"
+			},
+			"containers": [
+				{
+					"name": "c",
+					"image": "image"
+				}
+			]
+		}
+	}`
+	req, err := json.Marshal(&admissionv1beta1.AdmissionReview{
+		Request: &admissionv1beta1.AdmissionRequest{
+			UID:       "uid",
+			Operation: admissionv1beta1.Create,
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Object:    runtime.RawExtension{Raw: []byte(pod)},
+		}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name    string
+		config  *config
+		allowed bool
+	}{
+		{
+			name:    "option enabled, deny",
+			config:  &config{RejectPodOverhead: true},
+			allowed: false,
+		},
+		{
+			name:    "option disabled, allow",
+			config:  &config{},
+			allowed: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ac, err := NewAdmissionController(test.config, client, restricted)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := &http.Request{
+				Method: http.MethodPost,
+				Header: http.Header{"Content-Type": []string{"application/json"}},
+				Body:   ioutil.NopCloser(bytes.NewReader(req)),
+			}
+			w := newFakeResponseWriter()
+
+			ac.handleWhitelist(w, r)
+
+			if w.statusCode != 200 {
+				t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+			}
+			var rev *admissionv1beta1.AdmissionReview
+			err = json.NewDecoder(w).Decode(&rev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rev.Response.Allowed != test.allowed {
+				t.Errorf("got allowed %v, expected %v: %v", rev.Response.Allowed, test.allowed, rev.Response.Result)
+			}
+		})
+	}
+}
+
+// TestReservedResources verifies configured extended resources are denied for
+// non-whitelisted pods.
+func TestReservedResources(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAdmissionController(&config{
+		ReservedResources: []string{"example.com/gpu"},
+	}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &core.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+		Spec: core.PodSpec{
+			Containers: []core.Container{
+				{
+					Name:  "c",
+					Image: "image",
+					Resources: core.ResourceRequirements{
+						Limits: core.ResourceList{
+							"example.com/gpu": resource.MustParse("1"),
+						},
+					},
+				},
+			},
+		},
+	}
+	errs, _, err := ac.validatePodAgainstSCC(context.Background(), pod, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, e := range errs {
+		if strings.Contains(e.Error(), "example.com/gpu is reserved for platform workloads") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("reserved resource request was not denied: %v", errs)
+	}
+
+	//without the option the same pod passes
+	ac, err = NewAdmissionController(&config{}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs, _, err = ac.validatePodAgainstSCC(context.Background(), pod, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 0 {
+		t.Errorf("pod was denied without the option: %v", errs)
+	}
+}
+
+// TestAllowedUnsafeSysctls verifies configured sysctls are allowed for
+// non-whitelisted pods while anything outside the safe and configured sets
+// is still denied.
+func TestAllowedUnsafeSysctls(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAdmissionController(&config{
+		AllowedUnsafeSysctls: []string{"net.core.somaxconn"},
+	}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	podWithSysctl := func(name string) *core.Pod {
+		return &core.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+			Spec: core.PodSpec{
+				SecurityContext: &core.PodSecurityContext{
+					Sysctls: []core.Sysctl{{Name: name, Value: "1024"}},
+				},
+				Containers: []core.Container{
+					{
+						Name:  "c",
+						Image: "image",
+					},
+				},
+			},
+		}
+	}
+
+	errs, _, err := ac.validatePodAgainstSCC(context.Background(), podWithSysctl("net.core.somaxconn"), "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 0 {
+		t.Errorf("configured sysctl was denied: %v", errs)
+	}
+
+	errs, _, err = ac.validatePodAgainstSCC(context.Background(), podWithSysctl("kernel.msgmax"), "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, e := range errs {
+		if strings.Contains(e.Error(), `unsafe sysctl "kernel.msgmax" is not allowed`) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("unconfigured unsafe sysctl was not denied: %v", errs)
+	}
+
+	//without the option the configured sysctl is denied as well
+	ac, err = NewAdmissionController(&config{}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs, _, err = ac.validatePodAgainstSCC(context.Background(), podWithSysctl("net.core.somaxconn"), "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) == 0 {
+		t.Error("unsafe sysctl was allowed without the option")
+	}
+}
+
+// TestValidateNodeSelectorInitContainers verifies the option denies a
+// privileged, non-whitelisted init container on a master-selected pod which
+// the node-selector bypass would otherwise admit wholesale.
+func TestValidateNodeSelectorInitContainers(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &core.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+		Spec: core.PodSpec{
+			NodeSelector: map[string]string{"node-role.kubernetes.io/master": "true"},
+			InitContainers: []core.Container{
+				{
+					Name:            "init",
+					Image:           "regularimage",
+					SecurityContext: &core.SecurityContext{Privileged: toBoolPtr(true)},
+				},
+			},
+			Containers: []core.Container{
+				{
+					Name:  "c",
+					Image: "regularimage",
+				},
+			},
+		},
+	}
+
+	for _, test := range []struct {
+		name    string
+		strict  bool
+		allowed bool
+	}{
+		{name: "bypass admits the privileged init container", strict: false, allowed: true},
+		{name: "strict option denies the privileged init container", strict: true, allowed: false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ac, err := NewAdmissionController(&config{
+				ValidateNodeSelectorInitContainers: test.strict,
+			}, client, restricted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			errs, _, err := ac.validatePodAgainstSCC(context.Background(), pod, "default")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (len(errs) == 0) != test.allowed {
+				t.Errorf("got errs %v, expected allowed %v", errs, test.allowed)
+			}
+			if !test.allowed {
+				found := false
+				for _, e := range errs {
+					if e.Field == "spec.initContainers[0].securityContext.privileged" {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("expected a violation on the init container, got %v", errs)
+				}
+			}
+		})
+	}
+}
+
+// TestHandleDCTriggerCoverage verifies a privileged non-whitelisted image is
+// denied both on the DeploymentConfig itself and on the
+// ReplicationController a deployer (e.g. via an image-change trigger) would
+// create from its template, so a trigger-driven image swap cannot bypass the
+// DC-time review.
+func TestHandleDCTriggerCoverage(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAdmissionController(&config{}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := corev1.PodTemplateSpec{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name:            "mycontainer",
+					Image:           "regularimage",
+					SecurityContext: &corev1.SecurityContext{Privileged: toBoolPtr(true)},
+				},
+			},
+		},
+	}
+
+	dc := []byte(`{
+		"apiVersion": "apps.openshift.io/v1",
+		"kind": "DeploymentConfig",
+		"metadata": {
+			"name": "dc",
+			"namespace": "default"
+		},
+		"spec": {
+			"triggers": [
+				{
+					"type": "ImageChange",
+					"imageChangeParams": {
+						"automatic": true,
+						"containerNames": ["mycontainer"],
+						"from": {"kind": "ImageStreamTag", "name": "stream:latest"}
+					}
+				}
+			],
+			"template": {
+				"spec": {
+					"containers": [
+						{
+							"name": "mycontainer",
+							"image": "regularimage",
+							"securityContext": {"privileged": true}
+						}
+					]
+				}
+			}
+		}
+	}`)
+
+	rc, err := json.Marshal(&corev1.ReplicationController{
+		TypeMeta:   metav1.TypeMeta{Kind: "ReplicationController", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: "dc-1", Namespace: "default"},
+		Spec:       corev1.ReplicationControllerSpec{Template: &template},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name    string
+		kind    metav1.GroupVersionKind
+		payload []byte
+	}{
+		{name: "deploymentconfig denied", kind: metav1.GroupVersionKind{Group: "apps.openshift.io", Version: "v1", Kind: "DeploymentConfig"}, payload: dc},
+		{name: "replicationcontroller denied", kind: metav1.GroupVersionKind{Version: "v1", Kind: "ReplicationController"}, payload: rc},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := json.Marshal(&admissionv1beta1.AdmissionReview{
+				Request: &admissionv1beta1.AdmissionRequest{
+					UID:       "uid",
+					Operation: admissionv1beta1.Create,
+					Kind:      test.kind,
+					Object:    runtime.RawExtension{Raw: test.payload},
+				}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := &http.Request{
+				Method: http.MethodPost,
+				Header: http.Header{"Content-Type": []string{"application/json"}},
+				Body:   ioutil.NopCloser(bytes.NewReader(req)),
+			}
+			w := newFakeResponseWriter()
+
+			ac.handleWhitelist(w, r)
+
+			if w.statusCode != 200 {
+				t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+			}
+			var rev *admissionv1beta1.AdmissionReview
+			err = json.NewDecoder(w).Decode(&rev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rev.Response.Allowed {
+				t.Error("expected deny, got allow")
+			}
+		})
+	}
+}
+
+// TestRequiredDropCapabilities verifies whitelisted pods still have to drop
+// the configured capabilities.
+func TestRequiredDropCapabilities(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAdmissionController(&config{
+		Whitelist:                []string{"^whitelistedimage$"},
+		RequiredDropCapabilities: []string{"NET_RAW"},
+	}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name    string
+		drop    []core.Capability
+		allowed bool
+	}{
+		{
+			name:    "whitelisted pod missing the required drop, deny",
+			drop:    nil,
+			allowed: false,
+		},
+		{
+			name:    "whitelisted pod dropping the capability, allow",
+			drop:    []core.Capability{"NET_RAW"},
+			allowed: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			pod := &core.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+				Spec: core.PodSpec{
+					Containers: []core.Container{
+						{
+							Name:  "c",
+							Image: "whitelistedimage",
+							SecurityContext: &core.SecurityContext{
+								Privileged:   toBoolPtr(true),
+								Capabilities: &core.Capabilities{Drop: test.drop},
+							},
+						},
+					},
+				},
+			}
+			errs, matchedPatterns, err := ac.validatePodAgainstSCC(context.Background(), pod, "default")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.allowed {
+				if len(errs) != 0 {
+					t.Errorf("pod was denied: %v", errs)
+				}
+				if len(matchedPatterns) == 0 {
+					t.Error("expected the whitelist pattern to be recorded")
+				}
+			} else {
+				found := false
+				for _, e := range errs {
+					if strings.Contains(e.Error(), "whitelisted pods have to drop the NET_RAW capability") {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("missing required drop was not denied: %v", errs)
+				}
+			}
+		})
+	}
+}
+
+// TestRestrictNewSCCs verifies a new non-protected SCC allowing privileged
+// containers is denied for a regular admin and allowed for a member of the
+// configured elevated group.
+func TestRestrictNewSCCs(t *testing.T) {
+	ac, err := NewAdmissionController(&config{
+		RestrictNewSCCs:        true,
+		NewSCCPrivilegedGroups: []string{"osa-platform-admins"},
+	}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scc := `{
+		"apiVersion": "security.openshift.io/v1",
+		"kind": "SecurityContextConstraints",
+		"metadata": {
+			"name": "customer-privileged"
+		},
+		"allowPrivilegedContainer": true,
+		"fSGroup": {"type": "RunAsAny"},
+		"runAsUser": {"type": "RunAsAny"},
+		"seLinuxContext": {"type": "RunAsAny"},
+		"supplementalGroups": {"type": "RunAsAny"}
+	}`
+
+	for _, test := range []struct {
+		name    string
+		groups  []string
+		allowed bool
+	}{
+		{
+			name:    "regular admin, deny",
+			groups:  []string{"osa-customer-admins"},
+			allowed: false,
+		},
+		{
+			name:    "elevated group, allow",
+			groups:  []string{"osa-customer-admins", "osa-platform-admins"},
+			allowed: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := json.Marshal(&admissionv1beta1.AdmissionReview{
+				Request: &admissionv1beta1.AdmissionRequest{
+					UID:       "uid",
+					Operation: admissionv1beta1.Create,
+					Kind:      metav1.GroupVersionKind{Group: "security.openshift.io", Version: "v1", Kind: "SecurityContextConstraints"},
+					Name:      "customer-privileged",
+					Object:    runtime.RawExtension{Raw: []byte(scc)},
+					UserInfo:  authenticationv1.UserInfo{Username: "admin", Groups: test.groups},
+				}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := &http.Request{
+				Method: http.MethodPost,
+				Header: http.Header{"Content-Type": []string{"application/json"}},
+				Body:   ioutil.NopCloser(bytes.NewReader(req)),
+			}
+			w := newFakeResponseWriter()
+
+			ac.handleSCC(w, r)
+
+			if w.statusCode != 200 {
+				t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+			}
+			var rev *admissionv1beta1.AdmissionReview
+			err = json.NewDecoder(w).Decode(&rev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rev.Response.Allowed != test.allowed {
+				t.Errorf("got allowed %v, expected %v: %v", rev.Response.Allowed, test.allowed, rev.Response.Result)
+			}
+		})
+	}
+}
+
+// TestDenialLogIncludesResourceIdentity verifies the denial log line names
+// the operation, kind, namespace and name of the denied resource, so
+// production logs can be triaged without the request body.
+func TestDenialLogIncludesResourceIdentity(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	ac, err := NewAdmissionController(&config{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := newFakeResponseWriter()
+	req := &admissionv1beta1.AdmissionRequest{
+		UID:       "uid",
+		Operation: admissionv1beta1.Create,
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Name:      "badpod",
+		Namespace: "default",
+	}
+	ac.sendResult(errors.NewAggregate([]error{fmt.Errorf("Privileged containers are not allowed")}), w, req, nil)
+
+	if !strings.Contains(buf.String(), "Request uid: denying CREATE Pod default/badpod: 1 violations") {
+		t.Errorf("denial log does not carry the resource identity:\n%s", buf.String())
+	}
+}
+
+// TestInitContainerViolationFieldPath verifies a non-whitelisted pod whose
+// init container requests privileges is denied with a field path pointing at
+// the init container, not at spec.containers.
+func TestInitContainerViolationFieldPath(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAdmissionController(&config{}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &core.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+		Spec: core.PodSpec{
+			InitContainers: []core.Container{
+				{
+					Name:            "init",
+					Image:           "initimage",
+					SecurityContext: &core.SecurityContext{Privileged: toBoolPtr(true)},
+				},
+			},
+			Containers: []core.Container{
+				{Name: "c", Image: "regularimage"},
+			},
+		},
+	}
+	errs, _, err := ac.validatePodAgainstSCC(context.Background(), pod, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, e := range errs {
+		if strings.HasPrefix(e.Field, "spec.initContainers[0].securityContext") {
+			found = true
+		}
+		if strings.HasPrefix(e.Field, "spec.containers[1]") {
+			t.Errorf("init container violation misreported under %s", e.Field)
+		}
+	}
+	if !found {
+		t.Errorf("no violation points at the init container: %v", errs)
+	}
+}
+
+// TestWindowsInitContainerFieldPath verifies the Windows check reports a
+// privileged init container under spec.initContainers.
+func TestWindowsInitContainerFieldPath(t *testing.T) {
+	pod := &core.Pod{
+		Spec: core.PodSpec{
+			NodeSelector: map[string]string{"kubernetes.io/os": "windows"},
+			InitContainers: []core.Container{
+				{
+					Name:            "init",
+					Image:           "initimage",
+					SecurityContext: &core.SecurityContext{Privileged: toBoolPtr(true)},
+				},
+			},
+			Containers: []core.Container{
+				{Name: "c", Image: "image"},
+			},
+		},
+	}
+	errs := validateWindowsPod(pod)
+	if len(errs) != 1 {
+		t.Fatalf("got %d errors, expected 1: %v", len(errs), errs)
+	}
+	if errs[0].Field != "spec.initContainers[0].securityContext.privileged" {
+		t.Errorf("got field %s, expected spec.initContainers[0].securityContext.privileged", errs[0].Field)
+	}
+}
+
+// TestNilRestrictedSCC verifies a controller without a restricted SCC
+// reports a clear internal error instead of validating against a nil
+// baseline, and fails readiness.
+func TestNilRestrictedSCC(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	ac, err := NewAdmissionController(&config{}, client, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &core.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+		Spec: core.PodSpec{
+			Containers: []core.Container{{Name: "c", Image: "regularimage"}},
+		},
+	}
+	_, _, err = ac.validatePodAgainstSCC(context.Background(), pod, "default")
+	if err == nil || !strings.Contains(err.Error(), "no restricted SCC is loaded") {
+		t.Errorf("got error %v, expected the missing baseline to be reported", err)
+	}
+
+	r, err := http.NewRequest(http.MethodGet, "https://localhost:8443/healthz/ready", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newFakeResponseWriter()
+	ac.handleHealthzReady(w, r)
+	if w.statusCode != http.StatusServiceUnavailable {
+		t.Errorf("got status code %d, expected 503", w.statusCode)
+	}
+}
+
+// TestWarnOnSCCDefaults verifies an allowed pod relying on SCC defaulting
+// carries a warning describing the defaults the real SCC admission will
+// apply, and that the warning stays off without the option.
+func TestWarnOnSCCDefaults(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod, err := json.Marshal(&corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			//no securityContext anywhere: runAsUser and seLinuxOptions are
+			//left for the SCC to default
+			Containers: []corev1.Container{{Name: "c", Image: "regularimage"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := json.Marshal(&admissionv1beta1.AdmissionReview{
+		Request: &admissionv1beta1.AdmissionRequest{
+			UID:       "uid",
+			Operation: admissionv1beta1.Create,
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Object:    runtime.RawExtension{Raw: pod},
+		}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name       string
+		config     *config
+		expectWarn bool
+	}{
+		{name: "option enabled, warn", config: &config{WarnOnSCCDefaults: true}, expectWarn: true},
+		{name: "option disabled, no warning", config: &config{}, expectWarn: false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ac, err := NewAdmissionController(test.config, client, restricted)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := &http.Request{
+				Method: http.MethodPost,
+				Header: http.Header{"Content-Type": []string{"application/json"}},
+				Body:   ioutil.NopCloser(bytes.NewReader(req)),
+			}
+			w := newFakeResponseWriter()
+
+			ac.handleWhitelist(w, r)
+
+			if w.statusCode != 200 {
+				t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+			}
+			var rev *admissionReview
+			err = json.NewDecoder(w).Decode(&rev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !rev.Response.Allowed {
+				t.Fatalf("expected allow, got %v", rev.Response.Result)
+			}
+			warning := rev.Response.AuditAnnotations["aro-admission-controller/warnings"]
+			if test.expectWarn {
+				if !strings.Contains(warning, "runAsUser") {
+					t.Errorf("got warning %q, expected the defaulted runAsUser to be mentioned", warning)
+				}
+			} else if warning != "" {
+				t.Errorf("got unexpected warning %q", warning)
+			}
+		})
+	}
+}
+
+// TestEmptyImageNeverWhitelisted verifies a container with an empty image is
+// validated against restricted even when a whitelist pattern matches the
+// empty string.
+func TestEmptyImageNeverWhitelisted(t *testing.T) {
+	whitelistedImages := []*regexp.Regexp{regexp.MustCompile("^.*$")}
+
+	whitelisted, pattern := imageIsWhitelisted("", whitelistedImages, nil)
+	if whitelisted || pattern != "" {
+		t.Errorf("empty image was whitelisted by pattern %q", pattern)
+	}
+
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAdmissionController(&config{
+		Whitelist: []string{"^.*$"},
+	}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &core.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+		Spec: core.PodSpec{
+			Containers: []core.Container{
+				{
+					Name:            "c",
+					Image:           "",
+					SecurityContext: &core.SecurityContext{Privileged: toBoolPtr(true)},
+				},
+			},
+		},
+	}
+	errs, matchedPatterns, err := ac.validatePodAgainstSCC(context.Background(), pod, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matchedPatterns) != 0 {
+		t.Errorf("empty image matched whitelist patterns %v", matchedPatterns)
+	}
+	if len(errs) == 0 {
+		t.Error("privileged pod with an empty image was not validated against restricted")
+	}
+}
+
+// TestHandlePodExec verifies exec into a privileged pod is denied for
+// regular users and allowed for members of the configured groups.
+func TestHandlePodExec(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&core.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "privilegedpod", Namespace: "default"},
+			Spec: core.PodSpec{
+				Containers: []core.Container{
+					{
+						Name:            "c",
+						Image:           "whitelistedimage",
+						SecurityContext: &core.SecurityContext{Privileged: toBoolPtr(true)},
+					},
+				},
+			},
+		},
+		&core.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "regularpod", Namespace: "default"},
+			Spec: core.PodSpec{
+				Containers: []core.Container{{Name: "c", Image: "regularimage"}},
+			},
+		},
+	)
+	ac, err := NewAdmissionController(&config{
+		RestrictExecToPrivileged: true,
+		ExecPrivilegedGroups:     []string{"osa-customer-admins"},
+	}, client, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name    string
+		pod     string
+		groups  []string
+		allowed bool
+	}{
+		{
+			name:    "unauthorized exec into privileged pod, deny",
+			pod:     "privilegedpod",
+			groups:  []string{"system:authenticated"},
+			allowed: false,
+		},
+		{
+			name:    "authorized exec into privileged pod, allow",
+			pod:     "privilegedpod",
+			groups:  []string{"system:authenticated", "osa-customer-admins"},
+			allowed: true,
+		},
+		{
+			name:    "exec into regular pod, allow",
+			pod:     "regularpod",
+			groups:  []string{"system:authenticated"},
+			allowed: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := json.Marshal(&admissionv1beta1.AdmissionReview{
+				Request: &admissionv1beta1.AdmissionRequest{
+					UID:         "uid",
+					Operation:   admissionv1beta1.Connect,
+					Kind:        metav1.GroupVersionKind{Version: "v1", Kind: "PodExecOptions"},
+					Name:        test.pod,
+					Namespace:   "default",
+					SubResource: "exec",
+					UserInfo:    authenticationv1.UserInfo{Username: "user", Groups: test.groups},
+				}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := &http.Request{
+				Method: http.MethodPost,
+				Header: http.Header{"Content-Type": []string{"application/json"}},
+				Body:   ioutil.NopCloser(bytes.NewReader(req)),
+			}
+			w := newFakeResponseWriter()
+
+			ac.handlePodExec(w, r)
+
+			if w.statusCode != 200 {
+				t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+			}
+			var rev *admissionv1beta1.AdmissionReview
+			err = json.NewDecoder(w).Decode(&rev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rev.Response.Allowed != test.allowed {
+				t.Errorf("got allowed %v, expected %v: %v", rev.Response.Allowed, test.allowed, rev.Response.Result)
+			}
+		})
+	}
+}
+
+// TestVerifySCCCapabilityCase verifies lowercase capability names equal to
+// the uppercase template do not trigger a false modification denial.
+func TestVerifySCCCapabilityCase(t *testing.T) {
+	ac := &admissionController{}
+	templates := ac.InitProtectedSCCs()
+	template, found := templates["anyuid"]
+	if !found {
+		t.Fatal("no anyuid template")
+	}
+
+	scc := template.DeepCopy()
+	scc.Labels = map[string]string{"azure.openshift.io/owned-by-sync-pod": "true"}
+	for i, capability := range scc.RequiredDropCapabilities {
+		scc.RequiredDropCapabilities[i] = core.Capability(strings.ToLower(string(capability)))
+	}
+
+	errs := verifySCC(*scc, template)
+	if errs != nil {
+		t.Errorf("lowercase capabilities were denied: %v", errs)
+	}
+
+	//an actually different capability set is still denied
+	scc.RequiredDropCapabilities = append(scc.RequiredDropCapabilities, "SYS_ADMIN")
+	errs = verifySCC(*scc, template)
+	if errs == nil {
+		t.Error("a changed capability set was not denied")
+	}
+}
+
+// TestVerifySCCSubjectWhitespace verifies a whitespace-padded equivalent of
+// a protected user or group still counts as present in the superset check.
+func TestVerifySCCSubjectWhitespace(t *testing.T) {
+	ac := &admissionController{}
+	templates := ac.InitProtectedSCCs()
+	template, found := templates["anyuid"]
+	if !found {
+		t.Fatal("no anyuid template")
+	}
+	template.Users = []string{"system:serviceaccount:openshift-infra:build-controller"}
+	template.Groups = []string{"system:cluster-admins"}
+
+	scc := template.DeepCopy()
+	scc.Labels = map[string]string{"azure.openshift.io/owned-by-sync-pod": "true"}
+	scc.Users = []string{" system:serviceaccount:openshift-infra:build-controller "}
+	scc.Groups = []string{"system:cluster-admins "}
+
+	errs := verifySCC(*scc, template)
+	if errs != nil {
+		t.Errorf("padded equivalents were treated as removals: %v", errs)
+	}
+
+	//an actually removed user is still denied
+	scc.Users = []string{"system:serviceaccount:other:other"}
+	errs = verifySCC(*scc, template)
+	if errs == nil {
+		t.Error("a removed user was not denied")
+	}
+}
+
+// TestScaleSubresourceAllowed verifies a scale subresource request is
+// explicitly allowed: the pod template it leaves untouched was already
+// validated on the parent resource.
+func TestScaleSubresourceAllowed(t *testing.T) {
+	ac, err := NewAdmissionController(&config{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := json.Marshal(&admissionv1beta1.AdmissionReview{Request: &admissionv1beta1.AdmissionRequest{
+		UID:         "uid",
+		Kind:        metav1.GroupVersionKind{Group: "autoscaling", Version: "v1", Kind: "Scale"},
+		Resource:    metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+		SubResource: "scale",
+		Operation:   admissionv1beta1.Update,
+		Object:      runtime.RawExtension{Raw: []byte(`{"apiVersion": "autoscaling/v1", "kind": "Scale", "metadata": {"name": "dep", "namespace": "default"}, "spec": {"replicas": 3}}`)},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &http.Request{
+		Method: http.MethodPost,
+		Header: http.Header{"Content-Type": []string{"application/json"}},
+		Body:   ioutil.NopCloser(bytes.NewReader(req)),
+	}
+	w := newFakeResponseWriter()
+
+	ac.handleWhitelist(w, r)
+
+	if w.statusCode != 200 {
+		t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+	}
+	var rev *admissionv1beta1.AdmissionReview
+	err = json.NewDecoder(w).Decode(&rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rev.Response.Allowed {
+		t.Errorf("scale subresource request was not allowed: %#v", rev.Response.Result)
+	}
+}
+
+// brokenResponseWriter fails every Write, like a client which disconnected
+// before the response was sent.
+type brokenResponseWriter struct {
+	*fakeResponseWriter
+}
+
+func (w *brokenResponseWriter) Write(b []byte) (int, error) {
+	return 0, fmt.Errorf("connection reset")
+}
+
+// TestSendResultSurvivesWriteError verifies a failed response write is
+// logged instead of killing the process with its other in-flight requests.
+func TestSendResultSurvivesWriteError(t *testing.T) {
+	ac, err := NewAdmissionController(&config{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	w := &brokenResponseWriter{fakeResponseWriter: newFakeResponseWriter()}
+	ac.sendResult(nil, w, &admissionv1beta1.AdmissionRequest{UID: "uid"}, nil)
+
+	//reaching this point at all means the process survived the broken write
+	if !strings.Contains(buf.String(), "error encoding response") {
+		t.Errorf("the write error was not logged: %s", buf.String())
+	}
+}
+
+// TestUnsupportedSecurityFields verifies Windows hostProcess containers and
+// a non-default procMount, which the vendored internal types predate, are
+// denied for non-whitelisted pods and read from the raw object.
+func TestUnsupportedSecurityFields(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAdmissionController(&config{
+		Whitelist: []string{"^whitelistedimage$"},
+	}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	podWithSecurityContext := func(image, securityContext string) string {
+		return fmt.Sprintf(`{
+			"apiVersion": "v1",
+			"kind": "Pod",
+			"metadata": {
+				"name": "pod",
+				"namespace": "default"
+			},
+			"spec": {
+				"containers": [
+					{
+						"name": "c",
+						"image": "%s",
+						"securityContext": %s
+					}
+				]
+			}
+		}`, image, securityContext)
+	}
+
+	for _, test := range []struct {
+		name    string
+		pod     string
+		allowed bool
+		message string
+	}{
+		{
+			name:    "hostProcess container, deny",
+			pod:     podWithSecurityContext("image", `{"windowsOptions": {"hostProcess": true}}`),
+			allowed: false,
+			message: "hostProcess containers are not allowed",
+		},
+		{
+			name:    "non-default procMount, deny",
+			pod:     podWithSecurityContext("image", `{"procMount": "Unmasked"}`),
+			allowed: false,
+			message: "procMount Unmasked is not allowed",
+		},
+		{
+			name:    "default procMount, allow",
+			pod:     podWithSecurityContext("image", `{"procMount": "Default"}`),
+			allowed: true,
+		},
+		{
+			name:    "whitelisted pod keeps its fields, allow",
+			pod:     podWithSecurityContext("whitelistedimage", `{"windowsOptions": {"hostProcess": true}}`),
+			allowed: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := json.Marshal(&admissionv1beta1.AdmissionReview{
+				Request: &admissionv1beta1.AdmissionRequest{
+					UID:       "uid",
+					Operation: admissionv1beta1.Create,
+					Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+					Object:    runtime.RawExtension{Raw: []byte(test.pod)},
+				}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := &http.Request{
+				Method: http.MethodPost,
+				Header: http.Header{"Content-Type": []string{"application/json"}},
+				Body:   ioutil.NopCloser(bytes.NewReader(req)),
+			}
+			w := newFakeResponseWriter()
+
+			ac.handleWhitelist(w, r)
+
+			if w.statusCode != 200 {
+				t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+			}
+			var rev *admissionv1beta1.AdmissionReview
+			err = json.NewDecoder(w).Decode(&rev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rev.Response.Allowed != test.allowed {
+				t.Errorf("got allowed %v, expected %v: %#v", rev.Response.Allowed, test.allowed, rev.Response.Result)
+			}
+			if !test.allowed && !strings.Contains(rev.Response.Result.Message, test.message) {
+				t.Errorf("got message %q, expected it to contain %q", rev.Response.Result.Message, test.message)
+			}
+		})
+	}
+}
+
+// TestTrustedOwners verifies a privileged pod controller-owned by a trusted
+// kind is allowed without image whitelisting, while the same unowned pod and
+// pods carrying forged references to nonexistent owners are denied.
+func TestTrustedOwners(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAdmissionController(&config{
+		TrustedOwners: []string{"MachineConfigPool"},
+	}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the live owner a genuine reference resolves to; forged references miss
+	//it by name or by UID
+	ac.ownerClient = dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "machineconfiguration.openshift.io/v1",
+			"kind":       "MachineConfigPool",
+			"metadata": map[string]interface{}{
+				"name": "worker",
+				"uid":  "owner-uid",
+			},
+		},
+	})
+
+	podWithOwners := func(refs []metav1.OwnerReference) []byte {
+		pod, err := json.Marshal(&corev1.Pod{
+			TypeMeta: metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "pod",
+				Namespace:       "default",
+				OwnerReferences: refs,
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					{
+						Name:            "c",
+						Image:           "regularimage",
+						SecurityContext: &corev1.SecurityContext{Privileged: toBoolPtr(true)},
+					},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pod
+	}
+
+	for _, test := range []struct {
+		name    string
+		refs    []metav1.OwnerReference
+		allowed bool
+	}{
+		{
+			name:    "pod owned by a trusted controller, allow",
+			refs:    []metav1.OwnerReference{{APIVersion: "machineconfiguration.openshift.io/v1", Kind: "MachineConfigPool", Name: "worker", UID: "owner-uid", Controller: toBoolPtr(true)}},
+			allowed: true,
+		},
+		{
+			name:    "non-controller reference does not grant trust, deny",
+			refs:    []metav1.OwnerReference{{APIVersion: "machineconfiguration.openshift.io/v1", Kind: "MachineConfigPool", Name: "worker", UID: "owner-uid"}},
+			allowed: false,
+		},
+		{
+			name:    "reference to a nonexistent owner, deny",
+			refs:    []metav1.OwnerReference{{APIVersion: "machineconfiguration.openshift.io/v1", Kind: "MachineConfigPool", Name: "ghost", UID: "owner-uid", Controller: toBoolPtr(true)}},
+			allowed: false,
+		},
+		{
+			name:    "reference with a mismatched uid, deny",
+			refs:    []metav1.OwnerReference{{APIVersion: "machineconfiguration.openshift.io/v1", Kind: "MachineConfigPool", Name: "worker", UID: "spoofed-uid", Controller: toBoolPtr(true)}},
+			allowed: false,
+		},
+		{
+			name:    "unowned privileged pod, deny",
+			refs:    nil,
+			allowed: false,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := json.Marshal(&admissionv1beta1.AdmissionReview{
+				Request: &admissionv1beta1.AdmissionRequest{
+					UID:       "uid",
+					Operation: admissionv1beta1.Create,
+					Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+					Object:    runtime.RawExtension{Raw: podWithOwners(test.refs)},
+				}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := &http.Request{
+				Method: http.MethodPost,
+				Header: http.Header{"Content-Type": []string{"application/json"}},
+				Body:   ioutil.NopCloser(bytes.NewReader(req)),
+			}
+			w := newFakeResponseWriter()
+
+			ac.handleWhitelist(w, r)
+
+			if w.statusCode != 200 {
+				t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+			}
+			var rev *admissionv1beta1.AdmissionReview
+			err = json.NewDecoder(w).Decode(&rev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rev.Response.Allowed != test.allowed {
+				t.Errorf("got allowed %v, expected %v: %#v", rev.Response.Allowed, test.allowed, rev.Response.Result)
+			}
+		})
+	}
+}
+
+// TestHonorPrivilegedSCCBindings verifies a privileged pod is allowed when
+// its service account is granted the privileged SCC and the mode is on, and
+// denied otherwise.
+func TestHonorPrivilegedSCCBindings(t *testing.T) {
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod, err := json.Marshal(&corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			ServiceAccountName: "privileged-sa",
+			Containers: []corev1.Container{
+				{
+					Name:            "c",
+					Image:           "regularimage",
+					SecurityContext: &corev1.SecurityContext{Privileged: toBoolPtr(true)},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name       string
+		mode       bool
+		authorized bool
+		allowed    bool
+	}{
+		{
+			name:       "bound service account with the mode on, allow",
+			mode:       true,
+			authorized: true,
+			allowed:    true,
+		},
+		{
+			name:       "unbound service account with the mode on, deny",
+			mode:       true,
+			authorized: false,
+			allowed:    false,
+		},
+		{
+			name:       "bound service account with the mode off, deny",
+			mode:       false,
+			authorized: true,
+			allowed:    false,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(&core.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "default",
+					Annotations: map[string]string{
+						"openshift.io/sa.scc.uid-range": "1000/10",
+						"openshift.io/sa.scc.mcs":       "mcs",
+					},
+				},
+			})
+			authorized := test.authorized
+			client.PrependReactor("create", "subjectaccessreviews", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				sar, ok := action.(clienttesting.CreateAction).GetObject().(*authorizationapi.SubjectAccessReview)
+				if !ok {
+					t.Fatalf("unexpected SubjectAccessReview object %T", action.(clienttesting.CreateAction).GetObject())
+				}
+				if sar.Spec.User != "system:serviceaccount:default:privileged-sa" {
+					t.Errorf("got SubjectAccessReview for %q, expected the pod's service account", sar.Spec.User)
+				}
+				return true, &authorizationapi.SubjectAccessReview{
+					Status: authorizationapi.SubjectAccessReviewStatus{Allowed: authorized},
+				}, nil
+			})
+			ac, err := NewAdmissionController(&config{HonorPrivilegedSCCBindings: test.mode}, client, restricted)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req, err := json.Marshal(&admissionv1beta1.AdmissionReview{
+				Request: &admissionv1beta1.AdmissionRequest{
+					UID:       "uid",
+					Operation: admissionv1beta1.Create,
+					Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+					Object:    runtime.RawExtension{Raw: pod},
+				}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := &http.Request{
+				Method: http.MethodPost,
+				Header: http.Header{"Content-Type": []string{"application/json"}},
+				Body:   ioutil.NopCloser(bytes.NewReader(req)),
+			}
+			w := newFakeResponseWriter()
+
+			ac.handleWhitelist(w, r)
+
+			if w.statusCode != 200 {
+				t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+			}
+			var rev *admissionv1beta1.AdmissionReview
+			err = json.NewDecoder(w).Decode(&rev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rev.Response.Allowed != test.allowed {
+				t.Errorf("got allowed %v, expected %v: %#v", rev.Response.Allowed, test.allowed, rev.Response.Result)
+			}
+		})
+	}
+}
+
+// TestHandleSCCVerboseRawDump verifies the raw SCC object is only dumped to
+// the log when verbose SCC logging is enabled; TestHandleSCCNoRawDump covers
+// the default suppression.
+func TestHandleSCCVerboseRawDump(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	ac, err := NewAdmissionController(&config{VerboseSCCLogging: true}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := json.Marshal(&admissionv1beta1.AdmissionReview{
+		Request: &admissionv1beta1.AdmissionRequest{
+			UID:       "uid",
+			Operation: admissionv1beta1.Delete,
+			Name:      "notprotected",
+			Kind:      metav1.GroupVersionKind{Group: "security.openshift.io", Version: "v1", Kind: "SecurityContextConstraints"},
+			Resource:  metav1.GroupVersionResource{Group: "security.openshift.io", Version: "v1", Resource: "securitycontextconstraints"},
+			Object:    runtime.RawExtension{Raw: []byte(`{"metadata": {"name": "notprotected"}, "users": ["system:serviceaccount:ns:sa"]}`)},
+		}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &http.Request{
+		Method: http.MethodPost,
+		Header: http.Header{"Content-Type": []string{"application/json"}},
+		Body:   ioutil.NopCloser(bytes.NewReader(req)),
+	}
+	w := newFakeResponseWriter()
+
+	ac.handleSCC(w, r)
+
+	if w.statusCode != 200 {
+		t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+	}
+	if !strings.Contains(buf.String(), "raw SCC object") {
+		t.Errorf("the raw SCC was not dumped with verbose logging enabled: %s", buf.String())
+	}
+}
+
+// TestWhitelistProfiles verifies each container is held to the profile of
+// the whitelist pattern which matched its image: one whitelisted image may
+// run privileged while another, also whitelisted, may not.
+func TestWhitelistProfiles(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAdmissionController(&config{
+		Whitelist: []string{"^imagea$", "^imageb$"},
+		WhitelistProfiles: map[string]whitelistProfile{
+			"^imagea$": {AllowPrivileged: true},
+			"^imageb$": {AllowedCapabilities: []string{"NET_BIND_SERVICE"}},
+		},
+	}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privilegedPod := func(image string) *core.Pod {
+		return &core.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+			Spec: core.PodSpec{
+				Containers: []core.Container{
+					{
+						Name:            "c",
+						Image:           image,
+						SecurityContext: &core.SecurityContext{Privileged: toBoolPtr(true)},
+					},
+				},
+			},
+		}
+	}
+
+	errs, _, err := ac.validatePodAgainstSCC(context.Background(), privilegedPod("imagea"), "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 0 {
+		t.Errorf("imagea with a privileged profile was denied: %v", errs)
+	}
+
+	errs, _, err = ac.validatePodAgainstSCC(context.Background(), privilegedPod("imageb"), "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, e := range errs {
+		if strings.Contains(e.Error(), "does not allow privileged containers") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("imageb running privileged was not denied: %v", errs)
+	}
+
+	//capabilities outside the profile's set are denied
+	pod := &core.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+		Spec: core.PodSpec{
+			Containers: []core.Container{
+				{
+					Name:  "c",
+					Image: "imageb",
+					SecurityContext: &core.SecurityContext{
+						Capabilities: &core.Capabilities{Add: []core.Capability{"NET_BIND_SERVICE", "SYS_ADMIN"}},
+					},
+				},
+			},
+		},
+	}
+	errs, _, err = ac.validatePodAgainstSCC(context.Background(), pod, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found = false
+	for _, e := range errs {
+		if strings.Contains(e.Error(), "does not allow adding the SYS_ADMIN capability") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("the capability outside the profile was not denied: %v", errs)
+	}
+}
+
+// TestRefreshRestrictedSCC verifies a refresh picks up the live cluster SCC
+// as the new baseline, that the configured allowedCapabilities extension
+// survives the swap, and that cached decisions do not.
+func TestRefreshRestrictedSCC(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAdmissionController(&config{
+		AllowedCapabilities:         []string{"NET_BIND_SERVICE"},
+		RestrictedSCCRefreshSeconds: 30,
+		DecisionCacheTTLSeconds:     60,
+	}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	podWithCapability := func(name core.Capability) *core.Pod {
+		return &core.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+			Spec: core.PodSpec{
+				Containers: []core.Container{
+					{
+						Name:  "c",
+						Image: "image",
+						SecurityContext: &core.SecurityContext{
+							Capabilities: &core.Capabilities{Add: []core.Capability{name}},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	errs, _, err := ac.validatePodAgainstSCC(context.Background(), podWithCapability("NET_ADMIN"), "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) == 0 {
+		t.Error("NET_ADMIN was allowed against the bootstrap baseline")
+	}
+
+	//a decision computed under the bootstrap baseline must not outlive the
+	//refresh
+	ac.storeDecision("stale", nil, nil, nil)
+
+	//an admin-approved adjustment to the cluster's restricted SCC
+	clusterSCC := restricted.DeepCopy()
+	clusterSCC.AllowedCapabilities = append(clusterSCC.AllowedCapabilities, "NET_ADMIN")
+	ac.securityClient = securityfake.NewSimpleClientset(clusterSCC)
+
+	if err := ac.refreshRestrictedSCCOnce(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, cached := ac.cachedDecision("stale"); cached {
+		t.Error("a decision cached under the old baseline survived the refresh")
+	}
+
+	for _, capability := range []core.Capability{"NET_ADMIN", "NET_BIND_SERVICE"} {
+		errs, _, err = ac.validatePodAgainstSCC(context.Background(), podWithCapability(capability), "default")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(errs) != 0 {
+			t.Errorf("%s was denied against the refreshed baseline: %v", capability, errs)
+		}
+	}
+
+	errs, _, err = ac.validatePodAgainstSCC(context.Background(), podWithCapability("SYS_ADMIN"), "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) == 0 {
+		t.Error("SYS_ADMIN was allowed against the refreshed baseline")
+	}
+}
+
+// TestWhitelistProfilePullPolicy verifies a profile's allowedPullPolicies
+// constrains the imagePullPolicy of matched containers.
+func TestWhitelistProfilePullPolicy(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAdmissionController(&config{
+		Whitelist: []string{"^imagea$", "^imageb$"},
+		WhitelistProfiles: map[string]whitelistProfile{
+			//a mutable tag must be re-pulled and re-verified on every start
+			"^imagea$": {AllowedPullPolicies: []string{"Always"}},
+		},
+	}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	podWithPullPolicy := func(image string, policy core.PullPolicy) *core.Pod {
+		return &core.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+			Spec: core.PodSpec{
+				Containers: []core.Container{
+					{
+						Name:            "c",
+						Image:           image,
+						ImagePullPolicy: policy,
+					},
+				},
+			},
+		}
+	}
+
+	for _, test := range []struct {
+		name    string
+		image   string
+		policy  core.PullPolicy
+		allowed bool
+	}{
+		{name: "matching pull policy, allow", image: "imagea", policy: core.PullAlways, allowed: true},
+		{name: "mismatched pull policy, deny", image: "imagea", policy: core.PullIfNotPresent, allowed: false},
+		{name: "pattern without a profile is unconstrained", image: "imageb", policy: core.PullIfNotPresent, allowed: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			errs, _, err := ac.validatePodAgainstSCC(context.Background(), podWithPullPolicy(test.image, test.policy), "default")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.allowed != (len(errs) == 0) {
+				t.Errorf("got errors %v, expected allowed %v", errs, test.allowed)
+			}
+			if !test.allowed {
+				found := false
+				for _, e := range errs {
+					if strings.Contains(e.Error(), "requires an imagePullPolicy of Always, not IfNotPresent") {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("the denial does not name the required pull policy: %v", errs)
+				}
+			}
+		})
+	}
+}
+
+// TestSCCDenialNamesBaseline verifies a denial produced by the SCC matcher
+// names the baseline SCC and keeps a parseable field path, instead of the
+// old "provider restricted: " pseudo path segment.
+func TestSCCDenialNamesBaseline(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAdmissionController(&config{}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &core.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+		Spec: core.PodSpec{
+			Containers: []core.Container{
+				{
+					Name:            "c",
+					Image:           "image",
+					SecurityContext: &core.SecurityContext{Privileged: toBoolPtr(true)},
+				},
+			},
+		},
+	}
+	errs, _, err := ac.validatePodAgainstSCC(context.Background(), pod, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) == 0 {
+		t.Fatal("the privileged pod was not denied")
+	}
+
+	found := false
+	for _, e := range errs {
+		if strings.HasPrefix(e.Field, "spec.containers[0].securityContext") && strings.Contains(e.Error(), `validated against SCC "restricted"`) {
+			found = true
+		}
+		if strings.Contains(e.Field, "provider ") || strings.Contains(e.Field, " ") || strings.HasPrefix(e.Field, "pod.") {
+			t.Errorf("malformed field path in %q", e.Field)
+		}
+	}
+	if !found {
+		t.Errorf("no denial names the baseline SCC with a clean path: %v", errs)
+	}
+}
+
+// TestDefaultSCCAnnotation verifies a namespace can request an approved
+// bootstrap SCC as its validation baseline through the configured
+// annotation, and that unapproved requests keep the restricted baseline.
+func TestDefaultSCCAnnotation(t *testing.T) {
+	annotations := func(extra map[string]string) map[string]string {
+		a := map[string]string{
+			"openshift.io/sa.scc.uid-range": "1000/10",
+			"openshift.io/sa.scc.mcs":       "mcs",
+		}
+		for k, v := range extra {
+			a[k] = v
+		}
+		return a
+	}
+	client := fake.NewSimpleClientset(
+		&core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Annotations: annotations(nil)}},
+		&core.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        "tenant",
+			Annotations: annotations(map[string]string{"aro.openshift.io/default-scc": "nonroot"}),
+		}},
+		&core.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        "greedy",
+			Annotations: annotations(map[string]string{"aro.openshift.io/default-scc": "privileged"}),
+		}},
+	)
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAdmissionController(&config{
+		DefaultSCCAnnotation: "aro.openshift.io/default-scc",
+		ApprovedDefaultSCCs:  []string{"nonroot"},
+	}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//runAsUser 5000 is outside the namespace uid range restricted enforces,
+	//but nonroot allows any non-zero uid
+	uid := int64(5000)
+	pod := func(namespace string) *core.Pod {
+		return &core.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: namespace},
+			Spec: core.PodSpec{
+				Containers: []core.Container{
+					{
+						Name:            "c",
+						Image:           "image",
+						SecurityContext: &core.SecurityContext{RunAsUser: &uid},
+					},
+				},
+			},
+		}
+	}
+
+	for _, test := range []struct {
+		name      string
+		namespace string
+		allowed   bool
+	}{
+		{name: "namespace requesting nonroot, allow", namespace: "tenant", allowed: true},
+		{name: "namespace without the annotation, deny", namespace: "default", allowed: false},
+		{name: "namespace requesting an unapproved SCC, deny", namespace: "greedy", allowed: false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			errs, _, err := ac.validatePodAgainstSCC(context.Background(), pod(test.namespace), test.namespace)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.allowed != (len(errs) == 0) {
+				t.Errorf("got errors %v, expected allowed %v", errs, test.allowed)
+			}
+		})
+	}
+}
+
+// TestDenialReasonCodes verifies each denial class reports its stable reason
+// code in Result.Reason while the human-readable text stays in
+// Result.Message.
+func TestDenialReasonCodes(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAdmissionController(&config{
+		Whitelist:                   []string{"^whitelistedimage$"},
+		RequireFullyQualifiedImages: true,
+	}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uid := int64(5000)
+	marshalPod := func(image string, sc *corev1.SecurityContext) []byte {
+		raw, err := json.Marshal(&corev1.Pod{
+			TypeMeta:   metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+			ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					{Name: "c", Image: image, SecurityContext: sc},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	podRequest := func(raw []byte) *admissionv1beta1.AdmissionRequest {
+		return &admissionv1beta1.AdmissionRequest{
+			UID:       "uid",
+			Namespace: "default",
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+			Object:    runtime.RawExtension{Raw: raw},
+		}
+	}
+
+	for _, test := range []struct {
+		name    string
+		request *admissionv1beta1.AdmissionRequest
+		reason  metav1.StatusReason
+	}{
+		{
+			name:    "privileged non-whitelisted pod",
+			request: podRequest(marshalPod("image", &corev1.SecurityContext{Privileged: toBoolPtr(true)})),
+			reason:  reasonPrivilegedNotWhitelisted,
+		},
+		{
+			name:    "whitelisted pod with an unqualified image",
+			request: podRequest(marshalPod("whitelistedimage", &corev1.SecurityContext{Privileged: toBoolPtr(true)})),
+			reason:  reasonRegistryNotAllowed,
+		},
+		{
+			name:    "uid outside the namespace range",
+			request: podRequest(marshalPod("image", &corev1.SecurityContext{RunAsUser: &uid})),
+			reason:  reasonPolicyViolation,
+		},
+		{
+			name: "protected SCC delete",
+			request: &admissionv1beta1.AdmissionRequest{
+				UID:       "uid",
+				Name:      "privileged",
+				Operation: admissionv1beta1.Delete,
+				Kind:      metav1.GroupVersionKind{Group: "security.openshift.io", Version: "v1", Kind: "SecurityContextConstraints"},
+				Resource:  metav1.GroupVersionResource{Group: "security.openshift.io", Version: "v1", Resource: "securitycontextconstraints"},
+			},
+			reason: reasonSCCProtected,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			raw, err := json.Marshal(&admissionv1beta1.AdmissionReview{Request: test.request})
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := newFakeResponseWriter()
+			ac.handleValidate(w, &http.Request{
+				Method: http.MethodPost,
+				Header: http.Header{"Content-Type": []string{"application/json"}},
+				Body:   ioutil.NopCloser(bytes.NewReader(raw)),
+			})
+			if w.statusCode != 200 {
+				t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+			}
+			var rev *admissionv1beta1.AdmissionReview
+			if err := json.NewDecoder(w).Decode(&rev); err != nil {
+				t.Fatal(err)
+			}
+			if rev.Response.Allowed {
+				t.Fatalf("the request was not denied: %#v", rev.Response.Result)
+			}
+			if rev.Response.Result.Reason != test.reason {
+				t.Errorf("got reason %q, expected %q: %s", rev.Response.Result.Reason, test.reason, rev.Response.Result.Message)
+			}
+			if rev.Response.Result.Message == "" {
+				t.Error("the denial carries no human-readable message")
+			}
+		})
+	}
+}
+
+// TestDaemonSetUpdateStrategyOnlyChange verifies an update touching only a
+// DaemonSet's updateStrategy is fast-allowed by the unchanged-fields
+// short-circuit: the strategy lives outside the pod template, so the
+// privileged template below would otherwise be denied by full validation.
+func TestDaemonSetUpdateStrategyOnlyChange(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAdmissionController(&config{}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	marshalDS := func(strategy appsv1.DaemonSetUpdateStrategyType, image string) []byte {
+		raw, err := json.Marshal(&appsv1.DaemonSet{
+			TypeMeta:   metav1.TypeMeta{Kind: "DaemonSet", APIVersion: "apps/v1"},
+			ObjectMeta: metav1.ObjectMeta{Name: "ds", Namespace: "default"},
+			Spec: appsv1.DaemonSetSpec{
+				UpdateStrategy: appsv1.DaemonSetUpdateStrategy{Type: strategy},
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{
+							{
+								Name:            "c",
+								Image:           image,
+								SecurityContext: &corev1.SecurityContext{Privileged: toBoolPtr(true)},
+							},
+						},
+					},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+
+	for _, test := range []struct {
+		name    string
+		object  []byte
+		old     []byte
+		allowed bool
+	}{
+		{
+			name:    "updateStrategy-only change, fast allow",
+			object:  marshalDS(appsv1.RollingUpdateDaemonSetStrategyType, "privilegedimage"),
+			old:     marshalDS(appsv1.OnDeleteDaemonSetStrategyType, "privilegedimage"),
+			allowed: true,
+		},
+		{
+			name:    "updateStrategy change together with an image change, full validation denies",
+			object:  marshalDS(appsv1.RollingUpdateDaemonSetStrategyType, "otherprivilegedimage"),
+			old:     marshalDS(appsv1.OnDeleteDaemonSetStrategyType, "privilegedimage"),
+			allowed: false,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			raw, err := json.Marshal(&admissionv1beta1.AdmissionReview{Request: &admissionv1beta1.AdmissionRequest{
+				UID:       "uid",
+				Operation: admissionv1beta1.Update,
+				Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "DaemonSet"},
+				Resource:  metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "daemonsets"},
+				Object:    runtime.RawExtension{Raw: test.object},
+				OldObject: runtime.RawExtension{Raw: test.old},
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := newFakeResponseWriter()
+			ac.handleWhitelist(w, &http.Request{
+				Method: http.MethodPost,
+				Header: http.Header{"Content-Type": []string{"application/json"}},
+				Body:   ioutil.NopCloser(bytes.NewReader(raw)),
+			})
+			if w.statusCode != 200 {
+				t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+			}
+			var rev *admissionv1beta1.AdmissionReview
+			if err := json.NewDecoder(w).Decode(&rev); err != nil {
+				t.Fatal(err)
+			}
+			if rev.Response.Allowed != test.allowed {
+				t.Errorf("got allowed %v, expected %v: %#v", rev.Response.Allowed, test.allowed, rev.Response.Result)
+			}
+		})
+	}
+}
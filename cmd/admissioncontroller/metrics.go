@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync/atomic"
+)
+
+//The few values this controller exposes are written in the Prometheus text
+//format by hand rather than pulling a metrics library into the vendor tree.
+
+// limitInFlight bounds the number of admission reviews processed at once and
+// tracks the current count.  When the semaphore is saturated the request is
+// answered 429 immediately: under FailurePolicy Fail the API server retries,
+// so shedding load beats queueing it.
+func (ac *admissionController) limitInFlight(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ac.inFlightSemaphore != nil {
+			select {
+			case ac.inFlightSemaphore <- struct{}{}:
+				defer func() { <-ac.inFlightSemaphore }()
+			default:
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+		}
+		atomic.AddInt64(&ac.inFlightRequests, 1)
+		defer atomic.AddInt64(&ac.inFlightRequests, -1)
+		next(w, r)
+	}
+}
+
+// decisionKey identifies one series of the per-namespace decisions counter.
+type decisionKey struct {
+	namespace string
+	decision  string
+}
+
+// recordDecision counts an admission decision by namespace.  Only the
+// configured namespaces are labeled individually; the rest share the "other"
+// bucket, so the label cardinality stays bounded on clusters with thousands
+// of namespaces.
+func (ac *admissionController) recordDecision(namespace, decision string) {
+	if !ac.metricsNamespaces[namespace] {
+		namespace = "other"
+	}
+	ac.decisionCountLock.Lock()
+	defer ac.decisionCountLock.Unlock()
+	if ac.decisionCounts == nil {
+		ac.decisionCounts = map[decisionKey]int64{}
+	}
+	ac.decisionCounts[decisionKey{namespace: namespace, decision: decision}]++
+}
+
+// sccTamperKey identifies one series of the tamper-attempt counter: which
+// protected SCC was targeted and what kind of change was denied.
+type sccTamperKey struct {
+	scc    string
+	change string
+}
+
+// recordSCCTamperAttempt counts a denied modification of a protected SCC and
+// logs the actor, so security teams can alert on repeated tamper attempts.
+func (ac *admissionController) recordSCCTamperAttempt(scc, change, username string) {
+	log.Printf("Denied %s of protected SCC %s by %s", change, scc, username)
+	ac.sccTamperLock.Lock()
+	defer ac.sccTamperLock.Unlock()
+	if ac.sccTamperAttempts == nil {
+		ac.sccTamperAttempts = map[sccTamperKey]int64{}
+	}
+	ac.sccTamperAttempts[sccTamperKey{scc: scc, change: change}]++
+}
+
+func (ac *admissionController) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP aro_admission_controller_in_flight_requests Number of admission reviews currently being processed.\n")
+	fmt.Fprintf(w, "# TYPE aro_admission_controller_in_flight_requests gauge\n")
+	fmt.Fprintf(w, "aro_admission_controller_in_flight_requests %d\n", atomic.LoadInt64(&ac.inFlightRequests))
+	fmt.Fprintf(w, "# HELP aro_admission_controller_audit_denied_workloads Number of running pods the startup audit sweep found which would be denied.\n")
+	fmt.Fprintf(w, "# TYPE aro_admission_controller_audit_denied_workloads gauge\n")
+	fmt.Fprintf(w, "aro_admission_controller_audit_denied_workloads %d\n", atomic.LoadInt64(&ac.auditDeniedWorkloads))
+	fmt.Fprintf(w, "# HELP aro_admission_controller_decisions Number of admission decisions by namespace; namespaces outside the configured list share the other bucket.\n")
+	fmt.Fprintf(w, "# TYPE aro_admission_controller_decisions counter\n")
+	ac.decisionCountLock.Lock()
+	decisionKeys := make([]decisionKey, 0, len(ac.decisionCounts))
+	for k := range ac.decisionCounts {
+		decisionKeys = append(decisionKeys, k)
+	}
+	sort.Slice(decisionKeys, func(i, j int) bool {
+		if decisionKeys[i].namespace != decisionKeys[j].namespace {
+			return decisionKeys[i].namespace < decisionKeys[j].namespace
+		}
+		return decisionKeys[i].decision < decisionKeys[j].decision
+	})
+	for _, k := range decisionKeys {
+		fmt.Fprintf(w, "aro_admission_controller_decisions{namespace=%q,decision=%q} %d\n", k.namespace, k.decision, ac.decisionCounts[k])
+	}
+	ac.decisionCountLock.Unlock()
+	fmt.Fprintf(w, "# HELP aro_admission_controller_scc_tamper_attempts Number of denied modifications of protected SCCs.\n")
+	fmt.Fprintf(w, "# TYPE aro_admission_controller_scc_tamper_attempts counter\n")
+	ac.sccTamperLock.Lock()
+	keys := make([]sccTamperKey, 0, len(ac.sccTamperAttempts))
+	for k := range ac.sccTamperAttempts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].scc != keys[j].scc {
+			return keys[i].scc < keys[j].scc
+		}
+		return keys[i].change < keys[j].change
+	})
+	for _, k := range keys {
+		fmt.Fprintf(w, "aro_admission_controller_scc_tamper_attempts{scc=%q,change=%q} %d\n", k.scc, k.change, ac.sccTamperAttempts[k])
+	}
+	ac.sccTamperLock.Unlock()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+)
+
+// TestLimitInFlight saturates the limiter with a blocked request and verifies
+// the next request is shed with a 429 while the in-flight gauge reports it.
+func TestLimitInFlight(t *testing.T) {
+	ac, err := NewAdmissionController(&config{MaxInFlightRequests: 1}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	handler := ac.limitInFlight(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	})
+
+	go handler(newFakeResponseWriter(), &http.Request{})
+	<-entered
+
+	w := newFakeResponseWriter()
+	ac.handleMetrics(w, &http.Request{})
+	if !strings.Contains(w.Buffer.String(), "aro_admission_controller_in_flight_requests 1") {
+		t.Errorf("in-flight gauge does not report the blocked request: %s", w.Buffer.String())
+	}
+
+	w = newFakeResponseWriter()
+	handler(w, &http.Request{})
+	if w.statusCode != http.StatusTooManyRequests {
+		t.Errorf("got status code %d for the overflow request, expected 429", w.statusCode)
+	}
+
+	close(release)
+}
+
+// TestSCCTamperMetric verifies a denied modification of a protected SCC
+// increments the tamper-attempt counter labeled with the SCC name and the
+// attempted change type.
+func TestSCCTamperMetric(t *testing.T) {
+	ac, err := NewAdmissionController(&config{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		w := newFakeResponseWriter()
+		ac.validateSCCRequest(w, &admissionv1beta1.AdmissionRequest{
+			UID:       "uid",
+			Name:      "privileged",
+			Operation: admissionv1beta1.Delete,
+			UserInfo:  authenticationv1.UserInfo{Username: "eve"},
+		})
+		if w.statusCode != 200 {
+			t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+		}
+	}
+
+	w := newFakeResponseWriter()
+	ac.handleMetrics(w, &http.Request{})
+	if !strings.Contains(w.Buffer.String(), `aro_admission_controller_scc_tamper_attempts{scc="privileged",change="delete"} 2`) {
+		t.Errorf("tamper counter does not report the denied deletes: %s", w.Buffer.String())
+	}
+}
+
+// TestDecisionMetricCardinality verifies the per-namespace decision counter
+// keeps its label cardinality bounded: only configured namespaces get their
+// own series, everything else shares the "other" bucket.
+func TestDecisionMetricCardinality(t *testing.T) {
+	ac, err := NewAdmissionController(&config{
+		MetricsNamespaces: []string{"openshift-monitoring", "default"},
+	}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ac.recordDecision("openshift-monitoring", "allow")
+	ac.recordDecision("default", "deny")
+	for i := 0; i < 1000; i++ {
+		ac.recordDecision(fmt.Sprintf("customer-ns-%d", i), "allow")
+	}
+
+	w := newFakeResponseWriter()
+	ac.handleMetrics(w, &http.Request{})
+
+	namespaces := map[string]bool{}
+	for _, line := range strings.Split(w.Buffer.String(), "\n") {
+		if !strings.HasPrefix(line, "aro_admission_controller_decisions{") {
+			continue
+		}
+		start := strings.Index(line, `namespace="`) + len(`namespace="`)
+		namespaces[line[start:start+strings.Index(line[start:], `"`)]] = true
+	}
+	if len(namespaces) != 3 {
+		t.Errorf("got %d namespace labels %v, expected the 2 configured plus other", len(namespaces), namespaces)
+	}
+	if !strings.Contains(w.Buffer.String(), `aro_admission_controller_decisions{namespace="other",decision="allow"} 1000`) {
+		t.Errorf("unconfigured namespaces were not bucketed as other: %s", w.Buffer.String())
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/kubernetes/pkg/apis/core"
+)
+
+// patchOperation is a single RFC 6902 JSONPatch operation, the format the
+// API server expects in a mutating AdmissionResponse.
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// handleMutate is the opt-in mutating counterpart of the validating
+// handlers.  It never denies: it patches in the narrowly scoped defaults
+// safeDefaultPatch produces and allows the pod, leaving everything else to
+// the validating webhooks which still run afterwards.
+func (ac *admissionController) handleMutate(w http.ResponseWriter, r *http.Request) {
+	req, errcode := ac.getAdmissionReviewRequest(w, r)
+	if errcode != 0 {
+		http.Error(w, http.StatusText(errcode), errcode)
+		return
+	}
+	ac.mutateRequest(w, req)
+}
+
+func (ac *admissionController) mutateRequest(w http.ResponseWriter, req *admissionv1beta1.AdmissionRequest) {
+	var patch []patchOperation
+	if req.Kind.Kind == "Pod" && len(req.Object.Raw) > 0 {
+		gvk := schema.GroupVersionKind{Group: req.Kind.Group, Version: req.Kind.Version, Kind: req.Kind.Kind}
+		//a pod which cannot be decoded is passed through unpatched; the
+		//validating handler reports decode failures, this one must not
+		if o, _, err := codec.Decode(req.Object.Raw, &gvk, nil); err == nil {
+			if pod, ok := o.(*core.Pod); ok {
+				patch = ac.safeDefaultPatch(&pod.Spec)
+			}
+		}
+	}
+
+	rev := &admissionv1beta1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: admissionv1beta1.SchemeGroupVersion.String(),
+			Kind:       "AdmissionReview",
+		},
+		Response: &admissionv1beta1.AdmissionResponse{
+			UID:     req.UID,
+			Allowed: true,
+		},
+	}
+	if len(patch) > 0 {
+		body, err := json.Marshal(patch)
+		if err != nil {
+			log.Printf("Request %s: error encoding patch, allowing unpatched: %s", req.UID, err)
+		} else {
+			log.Printf("Request %s: defaulting %d fields on %s %s/%s", req.UID, len(patch), req.Kind.Kind, req.Namespace, req.Name)
+			patchType := admissionv1beta1.PatchTypeJSONPatch
+			rev.Response.Patch = body
+			rev.Response.PatchType = &patchType
+		}
+	}
+	w.Header().Set(requestUIDHeader, string(req.UID))
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(rev); err != nil {
+		log.Printf("Request %s: error encoding response: %s", req.UID, err)
+	}
+}
+
+// safeDefaultPatch returns the JSONPatch operations defaulting the fields
+// the configured validating options would deny when left unset.  Only
+// absent fields are defaulted: a value the pod sets itself, even a
+// non-compliant one, is left for validation to report.
+func (ac *admissionController) safeDefaultPatch(spec *core.PodSpec) []patchOperation {
+	var patch []patchOperation
+	if ac.requireTokenAutomountDisabled && spec.AutomountServiceAccountToken == nil {
+		patch = append(patch, patchOperation{Op: "add", Path: "/spec/automountServiceAccountToken", Value: false})
+	}
+	if len(ac.requiredDropCapabilities) > 0 {
+		//dropping a capability only removes privileges, and a whitelisted pod
+		//missing a required drop would be denied outright
+		patch = append(patch, dropCapabilityPatch(spec.InitContainers, "/spec/initContainers", ac.requiredDropCapabilities)...)
+		patch = append(patch, dropCapabilityPatch(spec.Containers, "/spec/containers", ac.requiredDropCapabilities)...)
+	}
+	return patch
+}
+
+// dropCapabilityPatch returns, per container, the operation adding the
+// required drop capabilities it is missing.  The patch path depends on how
+// much of the securityContext already exists, so the operation always adds
+// the deepest absent member.
+func dropCapabilityPatch(containers []core.Container, base string, required []string) []patchOperation {
+	var patch []patchOperation
+	for i, c := range containers {
+		var drop []string
+		dropped := map[core.Capability]bool{}
+		if c.SecurityContext != nil && c.SecurityContext.Capabilities != nil {
+			for _, cap := range c.SecurityContext.Capabilities.Drop {
+				dropped[cap] = true
+				drop = append(drop, string(cap))
+			}
+		}
+		missing := 0
+		for _, r := range required {
+			if !dropped[core.Capability(r)] {
+				drop = append(drop, r)
+				missing++
+			}
+		}
+		if missing == 0 {
+			continue
+		}
+		path := fmt.Sprintf("%s/%d/securityContext", base, i)
+		switch {
+		case c.SecurityContext == nil:
+			patch = append(patch, patchOperation{Op: "add", Path: path, Value: map[string]interface{}{"capabilities": map[string]interface{}{"drop": drop}}})
+		case c.SecurityContext.Capabilities == nil:
+			patch = append(patch, patchOperation{Op: "add", Path: path + "/capabilities", Value: map[string]interface{}{"drop": drop}})
+		default:
+			patch = append(patch, patchOperation{Op: "add", Path: path + "/capabilities/drop", Value: drop})
+		}
+	}
+	return patch
+}
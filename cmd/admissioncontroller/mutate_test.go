@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// TestMutateSafeDefaults verifies the mutating endpoint produces the
+// JSONPatch defaulting the fields the configured validating options would
+// deny when unset, and leaves already-set fields alone.
+func TestMutateSafeDefaults(t *testing.T) {
+	ac, err := NewAdmissionController(&config{
+		MutateSafeDefaults:            true,
+		RequireTokenAutomountDisabled: true,
+		RequiredDropCapabilities:      []string{"KILL"},
+	}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod, err := json.Marshal(&corev1.Pod{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Pod",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name:  "bare",
+					Image: "image",
+				},
+				{
+					Name:  "compliant",
+					Image: "image",
+					SecurityContext: &corev1.SecurityContext{
+						Capabilities: &corev1.Capabilities{Drop: []corev1.Capability{"KILL"}},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	review, err := json.Marshal(&admissionv1beta1.AdmissionReview{
+		Request: &admissionv1beta1.AdmissionRequest{
+			UID:      "uid",
+			Kind:     metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Resource: metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+			Object:   runtime.RawExtension{Raw: pod},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := newFakeResponseWriter()
+	ac.handleMutate(w, &http.Request{
+		Method: http.MethodPost,
+		Header: http.Header{"Content-Type": []string{"application/json"}},
+		Body:   ioutil.NopCloser(bytes.NewReader(review)),
+	})
+	if w.statusCode != 200 {
+		t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+	}
+
+	var response admissionv1beta1.AdmissionReview
+	if err := json.Unmarshal(w.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if !response.Response.Allowed {
+		t.Fatal("the mutating endpoint denied the pod")
+	}
+	if response.Response.PatchType == nil || *response.Response.PatchType != admissionv1beta1.PatchTypeJSONPatch {
+		t.Fatalf("got patch type %v, expected JSONPatch", response.Response.PatchType)
+	}
+
+	var patch []patchOperation
+	if err := json.Unmarshal(response.Response.Patch, &patch); err != nil {
+		t.Fatal(err)
+	}
+	//one op disabling the token automount, one adding the missing drop on
+	//the bare container; the compliant container must not be patched
+	if len(patch) != 2 {
+		t.Fatalf("got %d patch operations, expected 2: %v", len(patch), patch)
+	}
+	if patch[0].Op != "add" || patch[0].Path != "/spec/automountServiceAccountToken" || patch[0].Value != false {
+		t.Errorf("unexpected automount operation: %+v", patch[0])
+	}
+	if patch[1].Op != "add" || patch[1].Path != "/spec/containers/0/securityContext" {
+		t.Errorf("unexpected capability operation: %+v", patch[1])
+	}
+}
+
+// TestMutateAlreadyCompliant verifies a pod with every safe default already
+// set is allowed without a patch.
+func TestMutateAlreadyCompliant(t *testing.T) {
+	ac, err := NewAdmissionController(&config{
+		MutateSafeDefaults:            true,
+		RequireTokenAutomountDisabled: true,
+	}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod, err := json.Marshal(&corev1.Pod{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Pod",
+			APIVersion: "v1",
+		},
+		Spec: corev1.PodSpec{
+			AutomountServiceAccountToken: toBoolPtr(true),
+			Containers: []corev1.Container{
+				{
+					Name:  "c",
+					Image: "image",
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	review, err := json.Marshal(&admissionv1beta1.AdmissionReview{
+		Request: &admissionv1beta1.AdmissionRequest{
+			UID:      "uid",
+			Kind:     metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Resource: metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+			Object:   runtime.RawExtension{Raw: pod},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := newFakeResponseWriter()
+	ac.handleMutate(w, &http.Request{
+		Method: http.MethodPost,
+		Header: http.Header{"Content-Type": []string{"application/json"}},
+		Body:   ioutil.NopCloser(bytes.NewReader(review)),
+	})
+	if w.statusCode != 200 {
+		t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+	}
+
+	var response admissionv1beta1.AdmissionReview
+	if err := json.Unmarshal(w.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if !response.Response.Allowed {
+		t.Fatal("the mutating endpoint denied the pod")
+	}
+	//automountServiceAccountToken true is non-compliant but set by the pod
+	//itself; it is left for the validating webhook to deny
+	if len(response.Response.Patch) != 0 {
+		t.Errorf("unexpected patch: %s", response.Response.Patch)
+	}
+}
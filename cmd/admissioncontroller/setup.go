@@ -0,0 +1,442 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/kubernetes/pkg/api/legacyscheme"
+	"k8s.io/kubernetes/pkg/apis/admissionregistration"
+	"k8s.io/kubernetes/pkg/apis/core"
+	"k8s.io/kubernetes/pkg/apis/rbac"
+)
+
+const (
+	defaultWebhookServiceName      = "aro-admission-controller"
+	defaultWebhookServiceNamespace = "kube-system"
+	webhookConfigurationName       = "aro-admission-controller.redhat.com"
+	//webhookExclusionLabel marks namespaces the webhooks never review.
+	//Setup puts it on the controller's own namespace, so the controller's
+	//pods cannot be blocked by their own unavailable webhook during startup
+	//or upgrade
+	webhookExclusionLabel = webhookConfigurationName + "/exclude"
+)
+
+// webhookNamespaceSelector returns the selector excluding labeled namespaces
+// from every webhook rule.
+func webhookNamespaceSelector() *metav1.LabelSelector {
+	return &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{
+				Key:      webhookExclusionLabel,
+				Operator: metav1.LabelSelectorOpDoesNotExist,
+			},
+		},
+	}
+}
+
+// ensureOwnNamespaceExcluded labels the controller's own namespace with the
+// exclusion label before the webhook configurations referencing it are
+// registered.
+func (ac *admissionController) ensureOwnNamespaceExcluded() error {
+	_, namespace := ac.webhookService()
+	ns, err := ac.client.Core().Namespaces().Get(namespace, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if _, labeled := ns.Labels[webhookExclusionLabel]; labeled {
+		return nil
+	}
+	if ns.Labels == nil {
+		ns.Labels = map[string]string{}
+	}
+	ns.Labels[webhookExclusionLabel] = "true"
+	_, err = ac.client.Core().Namespaces().Update(ns)
+	return err
+}
+
+// webhookService returns the configured service name and namespace backing
+// the webhook, falling back to the defaults when unconfigured
+func (ac *admissionController) webhookService() (string, string) {
+	name, namespace := ac.webhookServiceName, ac.webhookServiceNamespace
+	if name == "" {
+		name = defaultWebhookServiceName
+	}
+	if namespace == "" {
+		namespace = defaultWebhookServiceNamespace
+	}
+	return name, namespace
+}
+
+// hookconfig lists the resources the admission controller validates.  Each
+// entry maps one mux path to the webhook rule registered for it in the
+// ValidatingWebhookConfiguration, with the operations the rule covers.
+var hookconfig = []struct {
+	path       string
+	group      string
+	versions   []string
+	resources  []string
+	operations []admissionregistration.OperationType
+}{
+	//pods/ephemeralcontainers covers kubectl debug containers attached to
+	//running pods
+	{path: "pods", group: "", versions: []string{"v1"}, resources: []string{"pods", "pods/ephemeralcontainers"}, operations: createUpdate},
+	//pods/exec is reviewed so exec into privileged pods can be restricted to
+	//configured groups; the handler allows everything when the option is off
+	{path: "podsexec", group: "", versions: []string{"v1"}, resources: []string{"pods/exec"}, operations: connectOnly},
+	//the beta apps versions are still submitted by older manifests and
+	//operators and must not bypass validation; the codec decodes all of them
+	//into the same internal types the unpackers expect
+	//replicationcontrollers close the DeploymentConfig trigger gap: an
+	//image-change trigger rewrites the pod template after DC admission, and
+	//the deployer-created RC is where the new image first reappears
+	{path: "replicationcontrollers", group: "", versions: []string{"v1"}, resources: []string{"replicationcontrollers"}, operations: createUpdate},
+	{path: "daemonsets", group: "apps", versions: []string{"v1", "v1beta2"}, resources: []string{"daemonsets"}, operations: createUpdate},
+	{path: "replicasets", group: "apps", versions: []string{"v1", "v1beta2"}, resources: []string{"replicasets"}, operations: createUpdate},
+	{path: "statefulsets", group: "apps", versions: []string{"v1", "v1beta1", "v1beta2"}, resources: []string{"statefulsets"}, operations: createUpdate},
+	{path: "deployments", group: "apps", versions: []string{"v1", "v1beta1", "v1beta2"}, resources: []string{"deployments"}, operations: createUpdate},
+	{path: "jobs", group: "batch", versions: []string{"v1"}, resources: []string{"jobs"}, operations: createUpdate},
+	{path: "cronjobs", group: "batch", versions: []string{"v1beta1"}, resources: []string{"cronjobs"}, operations: createUpdate},
+	{path: "deploymentconfigs", group: "apps.openshift.io", versions: []string{"v1"}, resources: []string{"deploymentconfigs"}, operations: createUpdate},
+	//protected SCCs additionally have to be guarded against Delete
+	{path: "securitycontextconstraints", group: "security.openshift.io", versions: []string{"v1"}, resources: []string{"securitycontextconstraints"}, operations: createUpdateDelete},
+}
+
+// logDecodableKinds logs, per group and version the webhook registers, the
+// kinds the codec can decode.  A webhook rule whose install package is not
+// linked into the binary would otherwise surface only as "no decoder is
+// registered" errors at request time; an empty list here makes the
+// misconfiguration obvious at startup.
+func logDecodableKinds() {
+	logged := map[schema.GroupVersion]bool{}
+	for _, h := range hookconfig {
+		for _, version := range h.versions {
+			gv := schema.GroupVersion{Group: h.group, Version: version}
+			if logged[gv] {
+				continue
+			}
+			logged[gv] = true
+			var kinds []string
+			for kind := range legacyscheme.Scheme.KnownTypes(gv) {
+				kinds = append(kinds, kind)
+			}
+			if len(kinds) == 0 {
+				log.Printf("Warning: the codec cannot decode any kind in %s; is its install package linked?", gv)
+				continue
+			}
+			sort.Strings(kinds)
+			log.Printf("Codec decodes kinds in %s: %s", gv, strings.Join(kinds, ", "))
+		}
+	}
+}
+
+var (
+	createUpdate       = []admissionregistration.OperationType{admissionregistration.Create, admissionregistration.Update}
+	connectOnly        = []admissionregistration.OperationType{admissionregistration.Connect}
+	createUpdateDelete = []admissionregistration.OperationType{admissionregistration.Create, admissionregistration.Update, admissionregistration.Delete}
+)
+
+// setupBackoff bounds the retries around the setup API calls: roughly
+// 1+2+4+8+16 seconds before giving up.
+var setupBackoff = wait.Backoff{
+	Duration: time.Second,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    5,
+}
+
+// createWithRetry retries create on transient API errors with the given
+// backoff and returns the last error once the backoff is exhausted.
+func createWithRetry(backoff wait.Backoff, kind string, create func() error) error {
+	var lastErr error
+	err := wait.ExponentialBackoff(backoff, func() (bool, error) {
+		lastErr = create()
+		if lastErr != nil {
+			log.Printf("Error creating %s, will retry: %s", kind, lastErr)
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil && lastErr != nil {
+		return lastErr
+	}
+	return err
+}
+
+// initializeValidatingWebhookConfiguration returns the
+// ValidatingWebhookConfiguration registering every hookconfig entry with the
+// API server, each with the operations its entry declares.
+func (ac *admissionController) initializeValidatingWebhookConfiguration(caBundle []byte) *admissionregistration.ValidatingWebhookConfiguration {
+	failurePolicy := admissionregistration.Fail
+	serviceName, serviceNamespace := ac.webhookService()
+	vwc := &admissionregistration.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: webhookConfigurationName,
+		},
+	}
+	for _, h := range hookconfig {
+		vwc.Webhooks = append(vwc.Webhooks, admissionregistration.Webhook{
+			Name: h.path + "." + webhookConfigurationName,
+			ClientConfig: admissionregistration.WebhookClientConfig{
+				Service: &admissionregistration.ServiceReference{
+					Namespace: serviceNamespace,
+					Name:      serviceName,
+					Path:      toStringPtr("/" + h.path),
+				},
+				CABundle: caBundle,
+			},
+			Rules: []admissionregistration.RuleWithOperations{
+				{
+					Operations: h.operations,
+					Rule: admissionregistration.Rule{
+						APIGroups:   []string{h.group},
+						APIVersions: h.versions,
+						Resources:   h.resources,
+					},
+				},
+			},
+			FailurePolicy:     &failurePolicy,
+			NamespaceSelector: webhookNamespaceSelector(),
+		})
+	}
+	return vwc
+}
+
+// reconcileValidatingWebhookConfiguration ensures the single
+// ValidatingWebhookConfiguration carries exactly the desired webhook set.  A
+// partial or stale configuration left by an older release is updated in
+// place: missing webhooks are added, stale ones removed and changed ones
+// replaced.
+func (ac *admissionController) reconcileValidatingWebhookConfiguration(caBundle []byte) error {
+	desired := ac.initializeValidatingWebhookConfiguration(caBundle)
+	_, err := ac.client.Admissionregistration().ValidatingWebhookConfigurations().Create(desired)
+	if !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	existing, err := ac.client.Admissionregistration().ValidatingWebhookConfigurations().Get(webhookConfigurationName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if reflect.DeepEqual(existing.Webhooks, desired.Webhooks) {
+		log.Print("ValidatingWebhookConfiguration already up to date")
+		return nil
+	}
+	log.Print("ValidatingWebhookConfiguration exists with a different webhook set, reconciling")
+	existing.Webhooks = desired.Webhooks
+	_, err = ac.client.Admissionregistration().ValidatingWebhookConfigurations().Update(existing)
+	return err
+}
+
+// initializeMutatingWebhookConfiguration returns the
+// MutatingWebhookConfiguration registering the /mutate endpoint for pods.
+// Its failure policy is Ignore: the safe defaults are best effort, and a
+// broken mutator must not block pod creation when the validating webhooks
+// still enforce the policy.
+func (ac *admissionController) initializeMutatingWebhookConfiguration(caBundle []byte) *admissionregistration.MutatingWebhookConfiguration {
+	failurePolicy := admissionregistration.Ignore
+	serviceName, serviceNamespace := ac.webhookService()
+	return &admissionregistration.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: webhookConfigurationName,
+		},
+		Webhooks: []admissionregistration.Webhook{
+			{
+				Name: "mutate." + webhookConfigurationName,
+				ClientConfig: admissionregistration.WebhookClientConfig{
+					Service: &admissionregistration.ServiceReference{
+						Namespace: serviceNamespace,
+						Name:      serviceName,
+						Path:      toStringPtr("/mutate"),
+					},
+					CABundle: caBundle,
+				},
+				Rules: []admissionregistration.RuleWithOperations{
+					{
+						Operations: createUpdate,
+						Rule: admissionregistration.Rule{
+							APIGroups:   []string{""},
+							APIVersions: []string{"v1"},
+							Resources:   []string{"pods"},
+						},
+					},
+				},
+				FailurePolicy:     &failurePolicy,
+				NamespaceSelector: webhookNamespaceSelector(),
+			},
+		},
+	}
+}
+
+// reconcileMutatingWebhookConfiguration mirrors
+// reconcileValidatingWebhookConfiguration for the mutating registration.
+func (ac *admissionController) reconcileMutatingWebhookConfiguration(caBundle []byte) error {
+	desired := ac.initializeMutatingWebhookConfiguration(caBundle)
+	_, err := ac.client.Admissionregistration().MutatingWebhookConfigurations().Create(desired)
+	if !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	existing, err := ac.client.Admissionregistration().MutatingWebhookConfigurations().Get(webhookConfigurationName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if reflect.DeepEqual(existing.Webhooks, desired.Webhooks) {
+		log.Print("MutatingWebhookConfiguration already up to date")
+		return nil
+	}
+	log.Print("MutatingWebhookConfiguration exists with a different webhook set, reconciling")
+	existing.Webhooks = desired.Webhooks
+	_, err = ac.client.Admissionregistration().MutatingWebhookConfigurations().Update(existing)
+	return err
+}
+
+const (
+	defaultPrivilegedCreatorRole  = "privileged-creator"
+	defaultPrivilegedCreatorGroup = "osa-customer-admins"
+)
+
+// privilegedCreatorBinding returns the configured ClusterRole, group and
+// binding names for the SCC-creator ClusterRoleBinding.  Unset fields fall
+// back to the defaults, so the names used at setup are never empty; the
+// default binding name is derived from the effective group and role.
+func (ac *admissionController) privilegedCreatorBinding() (role, group, binding string) {
+	role, group, binding = ac.privilegedCreatorRole, ac.privilegedCreatorGroup, ac.privilegedCreatorBindingName
+	if role == "" {
+		role = defaultPrivilegedCreatorRole
+	}
+	if group == "" {
+		group = defaultPrivilegedCreatorGroup
+	}
+	if binding == "" {
+		binding = group + "-" + role
+	}
+	return role, group, binding
+}
+
+// initializeClusterRoleBinding returns the ClusterRoleBinding allowing
+// customer admins to create SCCs, which this admission controller then
+// validates.
+func (ac *admissionController) initializeClusterRoleBinding() *rbac.ClusterRoleBinding {
+	role, group, binding := ac.privilegedCreatorBinding()
+	return &rbac.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: binding,
+		},
+		Subjects: []rbac.Subject{
+			{
+				Kind:     rbac.GroupKind,
+				APIGroup: rbac.GroupName,
+				Name:     group,
+			},
+		},
+		RoleRef: rbac.RoleRef{
+			APIGroup: rbac.GroupName,
+			Kind:     "ClusterRole",
+			Name:     role,
+		},
+	}
+}
+
+// waitForPodsReady polls until every aro-admission-controller pod reports
+// Ready, bounded by the configured setup timeout; without one it waits
+// forever, as before.
+func (ac *admissionController) waitForPodsReady(interval time.Duration) error {
+	serviceName, serviceNamespace := ac.webhookService()
+	allReady := func() (bool, error) {
+		pods, err := ac.client.Core().Pods(serviceNamespace).List(metav1.ListOptions{LabelSelector: "app=" + serviceName})
+		if err != nil {
+			log.Printf("Error listing aro-admission-controller pods: %s", err)
+			return false, nil
+		}
+		if len(pods.Items) == 0 {
+			return false, nil
+		}
+		for _, pod := range pods.Items {
+			ready := false
+			for _, cond := range pod.Status.Conditions {
+				if cond.Type == core.PodReady && cond.Status == core.ConditionTrue {
+					ready = true
+				}
+			}
+			if !ready {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+	if ac.setupTimeoutSeconds > 0 {
+		return wait.Poll(interval, time.Duration(ac.setupTimeoutSeconds)*time.Second, allReady)
+	}
+	return wait.PollInfinite(interval, allReady)
+}
+
+// setupAdmissionController waits until the admission controller pods are
+// ready to serve, then registers the ValidatingWebhookConfiguration and the
+// ClusterRoleBinding with the API server.  It is expected to run as a
+// goroutine next to the HTTP server, as the webhook cannot be registered
+// before the service backing it answers.
+func (ac *admissionController) setupAdmissionController() {
+	caBundle, err := ioutil.ReadFile("/etc/aro-admission-controller/ca.crt")
+	if err != nil {
+		log.Fatalf("Error reading CA bundle: %s", err)
+	}
+
+	err = ac.waitForPodsReady(10 * time.Second)
+	if err == wait.ErrWaitTimeout {
+		//a bad deploy can leave the pods unready forever; give up with a
+		//clear error and degrade readiness instead of blocking this
+		//goroutine indefinitely
+		log.Printf("Error: aro-admission-controller pods did not become ready within %d seconds, skipping webhook registration and marking readiness degraded", ac.setupTimeoutSeconds)
+		atomic.StoreInt64(&ac.setupDegraded, 1)
+		return
+	}
+	if err != nil {
+		log.Fatalf("Error waiting for aro-admission-controller pods: %s", err)
+	}
+
+	//the exclusion label has to be on the namespace before the webhook
+	//configurations referencing it exist, or a restart could brick itself
+	err = createWithRetry(setupBackoff, "namespace exclusion label", func() error {
+		return ac.ensureOwnNamespaceExcluded()
+	})
+	if err != nil {
+		log.Fatalf("Error labeling the controller's namespace: %s", err)
+	}
+
+	err = createWithRetry(setupBackoff, "ValidatingWebhookConfiguration", func() error {
+		return ac.reconcileValidatingWebhookConfiguration(caBundle)
+	})
+	if err != nil {
+		log.Fatalf("Error reconciling ValidatingWebhookConfiguration: %s", err)
+	}
+
+	if ac.mutateSafeDefaults {
+		err = createWithRetry(setupBackoff, "MutatingWebhookConfiguration", func() error {
+			return ac.reconcileMutatingWebhookConfiguration(caBundle)
+		})
+		if err != nil {
+			log.Fatalf("Error reconciling MutatingWebhookConfiguration: %s", err)
+		}
+	}
+
+	err = createWithRetry(setupBackoff, "ClusterRoleBinding", func() error {
+		_, err := ac.client.Rbac().ClusterRoleBindings().Create(ac.initializeClusterRoleBinding())
+		if apierrors.IsAlreadyExists(err) {
+			log.Print("ClusterRoleBinding already exists")
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		log.Fatalf("Error creating ClusterRoleBinding: %s", err)
+	}
+
+	log.Print("Aro Admission Controller setup complete.")
+}
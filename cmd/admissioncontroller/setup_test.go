@@ -0,0 +1,531 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/kubernetes/pkg/apis/admissionregistration"
+	"k8s.io/kubernetes/pkg/apis/core"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/fake"
+)
+
+func TestSetupHandlersRoutes(t *testing.T) {
+	ac := &admissionController{}
+	mux := ac.setupHandlers()
+
+	for _, path := range []string{
+		"/pods",
+		"/podsexec",
+		"/replicationcontrollers",
+		"/daemonsets",
+		"/replicasets",
+		"/statefulsets",
+		"/jobs",
+		"/cronjobs",
+		"/deploymentconfigs",
+		"/deployments",
+		"/securitycontextconstraints",
+		"/sccs",
+		"/validate",
+		"/healthz",
+		"/healthz/ready",
+		"/version",
+		"/metrics",
+		"/debug/config",
+		"/explain",
+	} {
+		r, err := http.NewRequest(http.MethodPost, "https://localhost:8443"+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, pattern := mux.Handler(r)
+		if pattern != path {
+			t.Errorf("no handler registered for %s, got pattern %q", path, pattern)
+		}
+	}
+}
+
+func TestInitializeValidatingWebhookConfigurationSCC(t *testing.T) {
+	ac := &admissionController{}
+	vwc := ac.initializeValidatingWebhookConfiguration([]byte("cabundle"))
+
+	var sccHook *admissionregistration.Webhook
+	for i, h := range vwc.Webhooks {
+		if h.ClientConfig.Service != nil && *h.ClientConfig.Service.Path == "/securitycontextconstraints" {
+			sccHook = &vwc.Webhooks[i]
+		}
+	}
+	if sccHook == nil {
+		t.Fatal("no webhook registered for /securitycontextconstraints")
+	}
+	if len(sccHook.Rules) != 1 {
+		t.Fatalf("got %d rules, expected 1", len(sccHook.Rules))
+	}
+	rule := sccHook.Rules[0]
+	if !reflect.DeepEqual(rule.APIGroups, []string{"security.openshift.io"}) {
+		t.Errorf("got APIGroups %v", rule.APIGroups)
+	}
+	if !reflect.DeepEqual(rule.Resources, []string{"securitycontextconstraints"}) {
+		t.Errorf("got Resources %v", rule.Resources)
+	}
+	expectedOps := []admissionregistration.OperationType{admissionregistration.Create, admissionregistration.Update, admissionregistration.Delete}
+	if !reflect.DeepEqual(rule.Operations, expectedOps) {
+		t.Errorf("got Operations %v, expected %v", rule.Operations, expectedOps)
+	}
+}
+
+// TestInitializeValidatingWebhookConfigurationOperations verifies each rule
+// carries exactly the operations its hookconfig entry declares: Delete shows
+// up only on the SCC rule.
+func TestInitializeValidatingWebhookConfigurationOperations(t *testing.T) {
+	ac := &admissionController{}
+	vwc := ac.initializeValidatingWebhookConfiguration([]byte("cabundle"))
+
+	if len(vwc.Webhooks) != len(hookconfig) {
+		t.Fatalf("got %d webhooks, expected %d", len(vwc.Webhooks), len(hookconfig))
+	}
+	for i, h := range hookconfig {
+		rule := vwc.Webhooks[i].Rules[0]
+		if !reflect.DeepEqual(rule.Operations, h.operations) {
+			t.Errorf("webhook %s: got operations %v, expected %v", h.path, rule.Operations, h.operations)
+		}
+		for _, op := range rule.Operations {
+			if op == admissionregistration.Delete && h.path != "securitycontextconstraints" {
+				t.Errorf("webhook %s unexpectedly registers Delete", h.path)
+			}
+		}
+	}
+}
+
+func TestHandleVersion(t *testing.T) {
+	ac := &admissionController{}
+
+	r, err := http.NewRequest(http.MethodGet, "https://localhost:8443/version", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newFakeResponseWriter()
+
+	ac.handleVersion(w, r)
+
+	var info versionInfo
+	err = json.NewDecoder(w).Decode(&info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the defaults apply when the build does not inject the ldflags
+	if info.Version == "" || info.GitCommit == "" || info.BuildDate == "" {
+		t.Errorf("got incomplete version info %#v", info)
+	}
+}
+
+// TestWebhookServiceOverride verifies a configured service name and
+// namespace are used in the generated webhook client configs.
+func TestWebhookServiceOverride(t *testing.T) {
+	ac, err := NewAdmissionController(&config{
+		WebhookServiceName:      "my-admission-controller",
+		WebhookServiceNamespace: "my-namespace",
+	}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vwc := ac.initializeValidatingWebhookConfiguration([]byte("cabundle"))
+	for _, h := range vwc.Webhooks {
+		if h.ClientConfig.Service.Name != "my-admission-controller" || h.ClientConfig.Service.Namespace != "my-namespace" {
+			t.Errorf("webhook %s targets service %s/%s, expected my-namespace/my-admission-controller", h.Name, h.ClientConfig.Service.Namespace, h.ClientConfig.Service.Name)
+		}
+	}
+
+	//the defaults apply when unconfigured
+	ac = &admissionController{}
+	name, namespace := ac.webhookService()
+	if name != "aro-admission-controller" || namespace != "kube-system" {
+		t.Errorf("got default service %s/%s", namespace, name)
+	}
+}
+
+// TestCreateWithRetry verifies a transient error on the first create is
+// retried and does not surface, while a persistent error eventually does.
+func TestCreateWithRetry(t *testing.T) {
+	backoff := wait.Backoff{Duration: time.Millisecond, Factor: 2, Steps: 3}
+
+	client := fake.NewSimpleClientset()
+	calls := 0
+	client.PrependReactor("create", "validatingwebhookconfigurations", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		calls++
+		if calls == 1 {
+			return true, nil, fmt.Errorf("transient error")
+		}
+		return false, nil, nil
+	})
+
+	ac := &admissionController{client: client}
+	err := createWithRetry(backoff, "ValidatingWebhookConfiguration", func() error {
+		_, err := ac.client.Admissionregistration().ValidatingWebhookConfigurations().Create(ac.initializeValidatingWebhookConfiguration([]byte("cabundle")))
+		return err
+	})
+	if err != nil {
+		t.Errorf("got error %q after transient failure, expected success", err)
+	}
+	if calls != 2 {
+		t.Errorf("create was called %d times, expected 2", calls)
+	}
+
+	err = createWithRetry(backoff, "ClusterRoleBinding", func() error {
+		return fmt.Errorf("persistent error")
+	})
+	if err == nil || err.Error() != "persistent error" {
+		t.Errorf("got error %v, expected the persistent error after exhausting retries", err)
+	}
+}
+
+// TestCreateWithRetryAlreadyExists verifies a typed AlreadyExists error from
+// the API server is treated as success rather than retried string-matched.
+func TestCreateWithRetryAlreadyExists(t *testing.T) {
+	backoff := wait.Backoff{Duration: time.Millisecond, Factor: 2, Steps: 3}
+
+	client := fake.NewSimpleClientset()
+	calls := 0
+	client.PrependReactor("create", "validatingwebhookconfigurations", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		calls++
+		return true, nil, apierrors.NewAlreadyExists(admissionregistration.Resource("validatingwebhookconfigurations"), webhookConfigurationName)
+	})
+
+	ac := &admissionController{client: client}
+	err := createWithRetry(backoff, "ValidatingWebhookConfiguration", func() error {
+		_, err := ac.client.Admissionregistration().ValidatingWebhookConfigurations().Create(ac.initializeValidatingWebhookConfiguration([]byte("cabundle")))
+		if apierrors.IsAlreadyExists(err) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		t.Errorf("got error %q for AlreadyExists, expected success", err)
+	}
+	if calls != 1 {
+		t.Errorf("create was called %d times, expected 1", calls)
+	}
+}
+
+// TestInitializeValidatingWebhookConfigurationBetaVersions verifies the beta
+// apps versions are registered so older manifests cannot bypass validation.
+func TestInitializeValidatingWebhookConfigurationBetaVersions(t *testing.T) {
+	ac := &admissionController{}
+	vwc := ac.initializeValidatingWebhookConfiguration([]byte("cabundle"))
+
+	versions := map[string][]string{}
+	for _, h := range vwc.Webhooks {
+		versions[*h.ClientConfig.Service.Path] = h.Rules[0].Rule.APIVersions
+	}
+	for path, expected := range map[string][]string{
+		"/daemonsets":   {"v1", "v1beta2"},
+		"/replicasets":  {"v1", "v1beta2"},
+		"/statefulsets": {"v1", "v1beta1", "v1beta2"},
+		"/deployments":  {"v1", "v1beta1", "v1beta2"},
+	} {
+		if !reflect.DeepEqual(versions[path], expected) {
+			t.Errorf("webhook %s registers versions %v, expected %v", path, versions[path], expected)
+		}
+	}
+}
+
+// TestReconcileValidatingWebhookConfiguration verifies an existing partial
+// configuration is reconciled to the full desired webhook set.
+func TestReconcileValidatingWebhookConfiguration(t *testing.T) {
+	ac := &admissionController{}
+	desired := ac.initializeValidatingWebhookConfiguration([]byte("cabundle"))
+
+	//an older release's configuration without the deployments webhook
+	partial := desired.DeepCopy()
+	var kept []admissionregistration.Webhook
+	for _, h := range partial.Webhooks {
+		if *h.ClientConfig.Service.Path != "/deployments" {
+			kept = append(kept, h)
+		}
+	}
+	partial.Webhooks = kept
+
+	client := fake.NewSimpleClientset(partial)
+	ac.client = client
+
+	err := ac.reconcileValidatingWebhookConfiguration([]byte("cabundle"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reconciled, err := client.Admissionregistration().ValidatingWebhookConfigurations().Get(webhookConfigurationName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reconciled.Webhooks, desired.Webhooks) {
+		t.Errorf("got %d webhooks after reconcile, expected the full set of %d", len(reconciled.Webhooks), len(desired.Webhooks))
+	}
+
+	//a second reconcile finds nothing to change
+	err = ac.reconcileValidatingWebhookConfiguration([]byte("cabundle"))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestWaitForPodsReadyTimeout verifies setup gives up cleanly when the pods
+// never become ready and the readiness endpoint reports it.
+func TestWaitForPodsReadyTimeout(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "aro-admission-controller-0",
+			Namespace: "kube-system",
+			Labels:    map[string]string{"app": "aro-admission-controller"},
+		},
+		Status: core.PodStatus{
+			Conditions: []core.PodCondition{
+				{Type: core.PodReady, Status: core.ConditionFalse},
+			},
+		},
+	})
+	ac := &admissionController{client: client, setupTimeoutSeconds: 1}
+
+	err := ac.waitForPodsReady(100 * time.Millisecond)
+	if err != wait.ErrWaitTimeout {
+		t.Fatalf("got error %v, expected the wait timeout", err)
+	}
+
+	//setup marks readiness degraded on timeout
+	ac.setupDegraded = 1
+	r, err := http.NewRequest(http.MethodGet, "https://localhost:8443/healthz/ready", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newFakeResponseWriter()
+	ac.handleHealthzReady(w, r)
+	if w.statusCode != http.StatusServiceUnavailable {
+		t.Errorf("got status code %d, expected 503", w.statusCode)
+	}
+}
+
+// TestPrivilegedCreatorBindingConfig verifies the generated
+// ClusterRoleBinding honors the configured role, group and binding names and
+// falls back to the defaults when unconfigured.
+func TestPrivilegedCreatorBindingConfig(t *testing.T) {
+	ac, err := NewAdmissionController(&config{
+		PrivilegedCreatorRole:        "scc-creator",
+		PrivilegedCreatorGroup:       "aro-customer-admins",
+		PrivilegedCreatorBindingName: "aro-scc-creators",
+	}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crb := ac.initializeClusterRoleBinding()
+	if crb.Name != "aro-scc-creators" {
+		t.Errorf("got binding name %q, expected aro-scc-creators", crb.Name)
+	}
+	if len(crb.Subjects) != 1 || crb.Subjects[0].Name != "aro-customer-admins" {
+		t.Errorf("got subjects %v, expected the configured group", crb.Subjects)
+	}
+	if crb.RoleRef.Name != "scc-creator" {
+		t.Errorf("got role %q, expected scc-creator", crb.RoleRef.Name)
+	}
+
+	//the defaults apply when unconfigured, including the derived binding name
+	ac, err = NewAdmissionController(&config{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crb = ac.initializeClusterRoleBinding()
+	if crb.Name != "osa-customer-admins-privileged-creator" {
+		t.Errorf("got binding name %q, expected the default", crb.Name)
+	}
+	if len(crb.Subjects) != 1 || crb.Subjects[0].Name != "osa-customer-admins" {
+		t.Errorf("got subjects %v, expected the default group", crb.Subjects)
+	}
+	if crb.RoleRef.Name != "privileged-creator" {
+		t.Errorf("got role %q, expected the default", crb.RoleRef.Name)
+	}
+}
+
+// TestHandler verifies the Handler accessor serves the review endpoints
+// through a plain httptest.Server, without TLS certificate files.
+func TestHandler(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAdmissionController(&config{}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(ac.Handler())
+	defer srv.Close()
+
+	body, err := json.Marshal(&admissionv1beta1.AdmissionReview{Request: &admissionv1beta1.AdmissionRequest{
+		UID:      "uid",
+		Kind:     metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Resource: metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+		Object:   runtime.RawExtension{Raw: []byte(`{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "pod", "namespace": "default"}, "spec": {"containers": [{"name": "c", "image": "image"}]}}`)},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Post(srv.URL+"/pods", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status code %d, expected 200", resp.StatusCode)
+	}
+	var rev *admissionv1beta1.AdmissionReview
+	err = json.NewDecoder(resp.Body).Decode(&rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rev.Response.Allowed {
+		t.Errorf("pod was not allowed: %#v", rev.Response.Result)
+	}
+}
+
+// TestHealthHandlers verifies the separate health listener's mux serves only
+// the health endpoints: an admission path must not be reachable on a
+// listener which never authenticates its callers.
+func TestHealthHandlers(t *testing.T) {
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAdmissionController(&config{}, nil, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(ac.healthHandlers())
+	defer srv.Close()
+
+	for _, test := range []struct {
+		path       string
+		statusCode int
+	}{
+		{path: "/healthz", statusCode: http.StatusOK},
+		{path: "/healthz/ready", statusCode: http.StatusOK},
+		{path: "/pods", statusCode: http.StatusNotFound},
+		{path: "/metrics", statusCode: http.StatusNotFound},
+	} {
+		resp, err := http.Get(srv.URL + test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.statusCode {
+			t.Errorf("got status code %d for %s, expected %d", resp.StatusCode, test.path, test.statusCode)
+		}
+	}
+}
+
+// TestOwnNamespaceExcluded verifies the generated webhook configurations
+// exclude namespaces carrying the exclusion label, that setup puts the label
+// on the controller's own namespace, and that the controller's own pods are
+// exempt even without the label, so the webhook can never block its own
+// startup.
+func TestOwnNamespaceExcluded(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "kube-system"},
+	})
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAdmissionController(&config{}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{
+				Key:      webhookExclusionLabel,
+				Operator: metav1.LabelSelectorOpDoesNotExist,
+			},
+		},
+	}
+	for _, webhook := range ac.initializeValidatingWebhookConfiguration(nil).Webhooks {
+		if !reflect.DeepEqual(webhook.NamespaceSelector, expected) {
+			t.Errorf("webhook %s does not exclude labeled namespaces: %#v", webhook.Name, webhook.NamespaceSelector)
+		}
+	}
+	for _, webhook := range ac.initializeMutatingWebhookConfiguration(nil).Webhooks {
+		if !reflect.DeepEqual(webhook.NamespaceSelector, expected) {
+			t.Errorf("webhook %s does not exclude labeled namespaces: %#v", webhook.Name, webhook.NamespaceSelector)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := ac.ensureOwnNamespaceExcluded(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ns, err := client.Core().Namespaces().Get("kube-system", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns.Labels[webhookExclusionLabel] != "true" {
+		t.Errorf("the controller's namespace is not labeled: %v", ns.Labels)
+	}
+
+	//the handler-level exemption covers the controller's own pods even if
+	//the namespace label was stripped
+	review, err := json.Marshal(&admissionv1beta1.AdmissionReview{
+		Request: &admissionv1beta1.AdmissionRequest{
+			UID:       "uid",
+			Operation: admissionv1beta1.Create,
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Object: runtime.RawExtension{Raw: []byte(`{
+				"apiVersion": "v1",
+				"kind": "Pod",
+				"metadata": {"name": "aro-admission-controller-0", "namespace": "kube-system", "labels": {"app": "aro-admission-controller"}},
+				"spec": {"containers": [{"name": "c", "image": "image", "securityContext": {"privileged": true}}]}
+			}`)},
+		}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newFakeResponseWriter()
+	ac.handleWhitelist(w, &http.Request{
+		Method: http.MethodPost,
+		Header: http.Header{"Content-Type": []string{"application/json"}},
+		Body:   ioutil.NopCloser(bytes.NewReader(review)),
+	})
+	if w.statusCode != 200 {
+		t.Fatalf("got status code %d, %s", w.statusCode, w.Buffer.String())
+	}
+	var rev *admissionv1beta1.AdmissionReview
+	if err := json.NewDecoder(w).Decode(&rev); err != nil {
+		t.Fatal(err)
+	}
+	if !rev.Response.Allowed {
+		t.Errorf("the controller's own pod was not allowed: %#v", rev.Response.Result)
+	}
+}
@@ -0,0 +1,13 @@
+package main
+
+// imageSignatureVerifier verifies that an image's signature is trusted
+// (e.g. cosign/sigstore against a customer-configured public key).  A
+// verifier is plugged in at construction time; this tree vendors no
+// signature client, so the default build runs without one and whitelisted
+// images are admitted on the whitelist match alone.  With a verifier set, an
+// image matching the whitelist is still denied when verification fails, so
+// a tampered or unsigned image cannot ride an existing whitelist entry.
+type imageSignatureVerifier interface {
+	//Verify returns nil when the image's signature is trusted
+	Verify(image string) error
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kubernetes/pkg/apis/core"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/fake"
+)
+
+// fakeSignatureVerifier trusts exactly the configured set of images.
+type fakeSignatureVerifier struct {
+	signed map[string]bool
+}
+
+func (v *fakeSignatureVerifier) Verify(image string) error {
+	if v.signed[image] {
+		return nil
+	}
+	return fmt.Errorf("no trusted signature for %s", image)
+}
+
+// TestSignatureVerification verifies a whitelisted image is only admitted
+// when the plugged-in verifier trusts its signature.
+func TestSignatureVerification(t *testing.T) {
+	client := fake.NewSimpleClientset(&core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"openshift.io/sa.scc.uid-range": "1000/10",
+				"openshift.io/sa.scc.mcs":       "mcs",
+			},
+		},
+	})
+	restricted, err := getRestrictedSCC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAdmissionController(&config{
+		Whitelist: []string{"^registry.example.com/.*$"},
+	}, client, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac.signatureVerifier = &fakeSignatureVerifier{
+		signed: map[string]bool{"registry.example.com/signed:v1": true},
+	}
+
+	for _, test := range []struct {
+		name    string
+		image   string
+		allowed bool
+	}{
+		{name: "signed whitelisted image, allow", image: "registry.example.com/signed:v1", allowed: true},
+		{name: "unsigned whitelisted image, deny", image: "registry.example.com/tampered:v1", allowed: false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			pod := &core.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+				Spec: core.PodSpec{
+					Containers: []core.Container{
+						{
+							Name:            "c",
+							Image:           test.image,
+							SecurityContext: &core.SecurityContext{Privileged: toBoolPtr(true)},
+						},
+					},
+				},
+			}
+			errs, matchedPatterns, err := ac.validatePodAgainstSCC(context.Background(), pod, "default")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.allowed {
+				if len(errs) != 0 {
+					t.Errorf("signed image was denied: %v", errs)
+				}
+				if len(matchedPatterns) == 0 {
+					t.Error("expected the whitelist pattern to be recorded")
+				}
+			} else {
+				found := false
+				for _, e := range errs {
+					if strings.Contains(e.Error(), "image signature verification failed") {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("unsigned image was not denied: %v", errs)
+				}
+			}
+		})
+	}
+}
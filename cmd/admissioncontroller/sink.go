@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// defaultDecisionSinkBuffer bounds the number of decision events queued for
+// the sink before new ones are dropped.
+const defaultDecisionSinkBuffer = 256
+
+// decisionEvent is the record forwarded to the configured decision sink for
+// every admission response this controller sends.
+type decisionEvent struct {
+	UID       string `json:"uid"`
+	Decision  string `json:"decision"`
+	Kind      string `json:"kind,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	User      string `json:"user,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// notifySink queues an event for the decision sink.  The queue is bounded and
+// events are dropped when it is full, so a slow or unreachable sink can never
+// block admission.
+func (ac *admissionController) notifySink(ev decisionEvent) {
+	if ac.decisionSink == nil {
+		return
+	}
+	select {
+	case ac.decisionSink <- ev:
+	default:
+		log.Printf("Decision sink queue full, dropping event for request %s", ev.UID)
+	}
+}
+
+// forwardDecisions drains the decision sink queue, POSTing each event as JSON
+// to the configured URL.  It runs as a goroutine next to the HTTP server;
+// delivery is best-effort and failures are only logged.
+func (ac *admissionController) forwardDecisions() {
+	for ev := range ac.decisionSink {
+		b, err := json.Marshal(ev)
+		if err != nil {
+			log.Printf("Error encoding decision event for request %s: %s", ev.UID, err)
+			continue
+		}
+		resp, err := http.Post(ac.decisionSinkURL, "application/json", bytes.NewReader(b))
+		if err != nil {
+			log.Printf("Error forwarding decision for request %s to sink: %s", ev.UID, err)
+			continue
+		}
+		resp.Body.Close()
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/errors"
+)
+
+// TestDecisionSink verifies a denial sent through sendResult reaches the
+// configured sink with the resource identity, user and reason.
+func TestDecisionSink(t *testing.T) {
+	received := make(chan decisionEvent, 1)
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev decisionEvent
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Errorf("sink received undecodable event: %s", err)
+		}
+		received <- ev
+	}))
+	defer sink.Close()
+
+	ac, err := NewAdmissionController(&config{
+		DecisionSinkURL: sink.URL,
+	}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ac.forwardDecisions()
+	defer close(ac.decisionSink)
+
+	w := newFakeResponseWriter()
+	req := &admissionv1beta1.AdmissionRequest{
+		UID:       "uid",
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Name:      "pod",
+		Namespace: "default",
+		UserInfo:  authenticationv1.UserInfo{Username: "user"},
+	}
+	ac.sendResult(errors.NewAggregate([]error{fmt.Errorf("Privileged containers are not allowed")}), w, req, nil)
+
+	select {
+	case ev := <-received:
+		expected := decisionEvent{
+			UID:       "uid",
+			Decision:  "deny",
+			Kind:      "Pod",
+			Name:      "pod",
+			Namespace: "default",
+			User:      "user",
+			Reason:    "Privileged containers are not allowed",
+		}
+		if ev != expected {
+			t.Errorf("sink received %+v, expected %+v", ev, expected)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("sink did not receive the decision event")
+	}
+}
+
+// TestDecisionSinkDoesNotBlock verifies admission responses complete even
+// when the sink queue is full and nothing is draining it.
+func TestDecisionSinkDoesNotBlock(t *testing.T) {
+	ac, err := NewAdmissionController(&config{
+		DecisionSinkURL:        "https://sink.invalid",
+		DecisionSinkBufferSize: 1,
+	}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//no forwarder is running; the second decision overflows the queue and
+	//has to be dropped rather than block the response
+	for i := 0; i < 2; i++ {
+		w := newFakeResponseWriter()
+		ac.sendResult(nil, w, &admissionv1beta1.AdmissionRequest{UID: "uid"}, nil)
+		if w.statusCode != 200 {
+			t.Errorf("got status code %d", w.statusCode)
+		}
+	}
+	if len(ac.decisionSink) != 1 {
+		t.Errorf("queue holds %d events, expected 1", len(ac.decisionSink))
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// build information, injected at build time via e.g.
+//
+//	go build -ldflags "-X main.version=v1.2.3 -X main.gitCommit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "unknown"
+	gitCommit = "unknown"
+	buildDate = "unknown"
+)
+
+type versionInfo struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"gitCommit"`
+	BuildDate string `json:"buildDate"`
+}
+
+// handleVersion reports which build is deployed, for correlating behaviour
+// changes with builds during incident response
+func (ac *admissionController) handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(versionInfo{
+		Version:   version,
+		GitCommit: gitCommit,
+		BuildDate: buildDate,
+	})
+	if err != nil {
+		log.Printf("Error encoding version info: %s", err)
+	}
+}